@@ -0,0 +1,841 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// binWriter writes JSON values in a binary format.
+type binWriter interface {
+	writeNil()
+	writeBool(bool)
+	writeInt(int64)
+	writeFloat(float64)
+	writeString(string)
+	writeArray(n int)
+	writeMap(n int)
+	Bytes() []byte
+}
+
+// writeValue writes val, which must be something that could have come
+// from unmarshalling JSON.  Numbers that are whole are written as
+// integers, since that is usually smaller.
+func writeValue(w binWriter, val interface{}) error {
+	switch t := val.(type) {
+	case nil:
+		w.writeNil()
+	case bool:
+		w.writeBool(t)
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return fmt.Errorf("%v cannot be represented in JSON", t)
+		}
+		if t == math.Trunc(t) && math.Abs(t) <= 1<<53 && !(t == 0 && math.Signbit(t)) {
+			w.writeInt(int64(t))
+		} else {
+			w.writeFloat(t)
+		}
+	case json.Number:
+		f, err := t.Float64()
+		if err != nil {
+			return err
+		}
+		return writeValue(w, f)
+	case string:
+		w.writeString(t)
+	case []interface{}:
+		w.writeArray(len(t))
+		for i := range t {
+			if err := writeValue(w, t[i]); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		w.writeMap(len(t))
+		for _, k := range sortedKeys(t) {
+			w.writeString(k)
+			if err := writeValue(w, t[k]); err != nil {
+				return err
+			}
+		}
+	case *utils.Object:
+		w.writeMap(t.Len())
+		for _, k := range t.Keys() {
+			v, _ := t.Get(k)
+			w.writeString(k)
+			if err := writeValue(w, v); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("Cannot encode %T", val)
+	}
+	return nil
+}
+
+// writePatch writes p as an array of maps, the same shape it has as JSON.
+func writePatch(w binWriter, p Patch) error {
+	w.writeArray(len(p))
+	for _, op := range p {
+		n := 2
+		switch op.Op {
+		case "copy", "move", "add", "replace", "test":
+			n++
+		}
+		w.writeMap(n)
+		w.writeString("op")
+		w.writeString(op.Op)
+		w.writeString("path")
+		w.writeString(op.Path.String())
+		switch op.Op {
+		case "copy", "move":
+			w.writeString("from")
+			w.writeString(op.From.String())
+		case "add", "replace", "test":
+			w.writeString("value")
+			if err := writeValue(w, op.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// maxBinaryDepth limits how deeply values in a binary patch can nest,
+// so that untrusted input cannot exhaust the stack.  It is the same
+// limit encoding/json uses.
+const maxBinaryDepth = 10000
+
+// binReader reads bytes for a binary format decoder.
+type binReader struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+// enter notes that a value is being read inside another one, and fails
+// if values are nested too deeply.  Call leave when done with it.
+func (r *binReader) enter() error {
+	r.depth++
+	if r.depth > maxBinaryDepth {
+		return fmt.Errorf("Values nested more than %d deep", maxBinaryDepth)
+	}
+	return nil
+}
+
+func (r *binReader) leave() {
+	r.depth--
+}
+
+func (r *binReader) next(n uint64) ([]byte, error) {
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, fmt.Errorf("Unexpected end of data")
+	}
+	res := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return res, nil
+}
+
+func (r *binReader) byte() (byte, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// uint reads an n byte big-endian unsigned integer.
+func (r *binReader) uint(n int) (uint64, error) {
+	b, err := r.next(uint64(n))
+	if err != nil {
+		return 0, err
+	}
+	var res uint64
+	for _, c := range b {
+		res = res<<8 | uint64(c)
+	}
+	return res, nil
+}
+
+// count checks that n items could fit in what is left of the data,
+// so that corrupt lengths do not make us allocate huge slices.
+func (r *binReader) count(n uint64) (int, error) {
+	if n > uint64(len(r.buf)-r.pos) {
+		return 0, fmt.Errorf("Unexpected end of data")
+	}
+	return int(n), nil
+}
+
+func (r *binReader) string(n uint64) (string, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("String is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+func checkFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, fmt.Errorf("%v cannot be represented in JSON", f)
+	}
+	return f, nil
+}
+
+// msgpackWriter writes MessagePack.
+type msgpackWriter struct {
+	bytes.Buffer
+}
+
+func (w *msgpackWriter) writeNil() {
+	w.WriteByte(0xc0)
+}
+
+func (w *msgpackWriter) writeBool(b bool) {
+	if b {
+		w.WriteByte(0xc3)
+	} else {
+		w.WriteByte(0xc2)
+	}
+}
+
+func (w *msgpackWriter) writeUint(prefix byte, v uint64, n int) {
+	w.WriteByte(prefix)
+	for i := n - 1; i >= 0; i-- {
+		w.WriteByte(byte(v >> (8 * uint(i))))
+	}
+}
+
+func (w *msgpackWriter) writeInt(v int64) {
+	switch {
+	case v >= 0 && v < 128, v < 0 && v >= -32:
+		w.WriteByte(byte(v))
+	case v >= 0 && v < 1<<8:
+		w.writeUint(0xcc, uint64(v), 1)
+	case v >= 0 && v < 1<<16:
+		w.writeUint(0xcd, uint64(v), 2)
+	case v >= 0 && v < 1<<32:
+		w.writeUint(0xce, uint64(v), 4)
+	case v >= 0:
+		w.writeUint(0xcf, uint64(v), 8)
+	case v >= math.MinInt8:
+		w.writeUint(0xd0, uint64(v), 1)
+	case v >= math.MinInt16:
+		w.writeUint(0xd1, uint64(v), 2)
+	case v >= math.MinInt32:
+		w.writeUint(0xd2, uint64(v), 4)
+	default:
+		w.writeUint(0xd3, uint64(v), 8)
+	}
+}
+
+func (w *msgpackWriter) writeFloat(f float64) {
+	w.writeUint(0xcb, math.Float64bits(f), 8)
+}
+
+func (w *msgpackWriter) writeString(s string) {
+	switch n := len(s); {
+	case n < 32:
+		w.WriteByte(0xa0 | byte(n))
+	case n < 1<<8:
+		w.writeUint(0xd9, uint64(n), 1)
+	case n < 1<<16:
+		w.writeUint(0xda, uint64(n), 2)
+	default:
+		w.writeUint(0xdb, uint64(n), 4)
+	}
+	w.WriteString(s)
+}
+
+func (w *msgpackWriter) writeArray(n int) {
+	switch {
+	case n < 16:
+		w.WriteByte(0x90 | byte(n))
+	case n < 1<<16:
+		w.writeUint(0xdc, uint64(n), 2)
+	default:
+		w.writeUint(0xdd, uint64(n), 4)
+	}
+}
+
+func (w *msgpackWriter) writeMap(n int) {
+	switch {
+	case n < 16:
+		w.WriteByte(0x80 | byte(n))
+	case n < 1<<16:
+		w.writeUint(0xde, uint64(n), 2)
+	default:
+		w.writeUint(0xdf, uint64(n), 4)
+	}
+}
+
+// readMsgpack reads a MessagePack value into the same sort of value
+// json.Unmarshal would produce.
+func (r *binReader) readMsgpack() (interface{}, error) {
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	var n uint64
+	switch {
+	case b <= 0x7f:
+		return float64(b), nil
+	case b >= 0xe0:
+		return float64(int8(b)), nil
+	case b <= 0x8f:
+		return r.msgpackMap(uint64(b & 0x0f))
+	case b <= 0x9f:
+		return r.msgpackArray(uint64(b & 0x0f))
+	case b <= 0xbf:
+		return r.string(uint64(b & 0x1f))
+	}
+	switch b {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		if n, err = r.uint(4); err != nil {
+			return nil, err
+		}
+		return checkFloat(float64(math.Float32frombits(uint32(n))))
+	case 0xcb:
+		if n, err = r.uint(8); err != nil {
+			return nil, err
+		}
+		return checkFloat(math.Float64frombits(n))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err = r.uint(1 << (b - 0xcc))
+		return float64(n), err
+	case 0xd0:
+		n, err = r.uint(1)
+		return float64(int8(n)), err
+	case 0xd1:
+		n, err = r.uint(2)
+		return float64(int16(n)), err
+	case 0xd2:
+		n, err = r.uint(4)
+		return float64(int32(n)), err
+	case 0xd3:
+		n, err = r.uint(8)
+		return float64(int64(n)), err
+	case 0xd9, 0xda, 0xdb:
+		if n, err = r.uint(1 << (b - 0xd9)); err != nil {
+			return nil, err
+		}
+		return r.string(n)
+	case 0xdc, 0xdd:
+		if n, err = r.uint(2 << (b - 0xdc)); err != nil {
+			return nil, err
+		}
+		return r.msgpackArray(n)
+	case 0xde, 0xdf:
+		if n, err = r.uint(2 << (b - 0xde)); err != nil {
+			return nil, err
+		}
+		return r.msgpackMap(n)
+	case 0xc4, 0xc5, 0xc6:
+		return nil, fmt.Errorf("Binary data cannot be represented in JSON")
+	default:
+		return nil, fmt.Errorf("Unsupported MessagePack type 0x%02x", b)
+	}
+}
+
+func (r *binReader) msgpackArray(n uint64) (interface{}, error) {
+	count, err := r.count(n)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]interface{}, count)
+	for i := range res {
+		if res[i], err = r.readMsgpack(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (r *binReader) msgpackMap(n uint64) (interface{}, error) {
+	count, err := r.count(n)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{}, count)
+	for i := 0; i < count; i++ {
+		k, err := r.readMsgpack()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("Map key %v is not a string", k)
+		}
+		if res[key], err = r.readMsgpack(); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// cborWriter writes CBOR, as defined in RFC 8949.
+type cborWriter struct {
+	bytes.Buffer
+}
+
+// head writes the initial bytes of an item of type major with argument n.
+func (w *cborWriter) head(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		w.WriteByte(major | byte(n))
+	case n < 1<<8:
+		w.WriteByte(major | 24)
+		w.WriteByte(byte(n))
+	case n < 1<<16:
+		w.WriteByte(major | 25)
+		w.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n < 1<<32:
+		w.WriteByte(major | 26)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		w.WriteByte(major | 27)
+		w.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func (w *cborWriter) writeNil() {
+	w.WriteByte(0xf6)
+}
+
+func (w *cborWriter) writeBool(b bool) {
+	if b {
+		w.WriteByte(0xf5)
+	} else {
+		w.WriteByte(0xf4)
+	}
+}
+
+func (w *cborWriter) writeInt(v int64) {
+	if v >= 0 {
+		w.head(0, uint64(v))
+	} else {
+		w.head(1, uint64(-1-v))
+	}
+}
+
+func (w *cborWriter) writeFloat(f float64) {
+	w.WriteByte(0xfb)
+	w.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(f)))
+}
+
+func (w *cborWriter) writeString(s string) {
+	w.head(3, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func (w *cborWriter) writeArray(n int) {
+	w.head(4, uint64(n))
+}
+
+func (w *cborWriter) writeMap(n int) {
+	w.head(5, uint64(n))
+}
+
+// cborBreak is returned by readCBOR when it reads the end of an
+// indefinite-length item.
+type cborBreak struct{}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+	var res float64
+	switch exp {
+	case 0:
+		res = math.Ldexp(frac, -24)
+	case 31:
+		if frac == 0 {
+			res = math.Inf(1)
+		} else {
+			res = math.NaN()
+		}
+	default:
+		res = math.Ldexp(frac+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		res = -res
+	}
+	return res
+}
+
+// readCBOR reads a CBOR value into the same sort of value
+// json.Unmarshal would produce.  Tags are ignored.
+func (r *binReader) readCBOR() (interface{}, error) {
+	if err := r.enter(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+	b, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	major, info := b>>5, b&0x1f
+	var n uint64
+	indefinite := false
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		if n, err = r.uint(1 << (info - 24)); err != nil {
+			return nil, err
+		}
+	case info == 31 && major >= 2 && major <= 5:
+		indefinite = true
+	case info == 31 && major == 7:
+		return cborBreak{}, nil
+	default:
+		return nil, fmt.Errorf("Invalid CBOR item 0x%02x", b)
+	}
+	switch major {
+	case 0:
+		return float64(n), nil
+	case 1:
+		return -1 - float64(n), nil
+	case 2:
+		return nil, fmt.Errorf("Binary data cannot be represented in JSON")
+	case 3:
+		if !indefinite {
+			return r.string(n)
+		}
+		res := ""
+		for {
+			chunk, err := r.readCBOR()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := chunk.(cborBreak); ok {
+				return res, nil
+			}
+			s, ok := chunk.(string)
+			if !ok {
+				return nil, fmt.Errorf("Invalid chunk in CBOR string")
+			}
+			res += s
+		}
+	case 4:
+		res := []interface{}{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			val, err := r.readCBOR()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := val.(cborBreak); ok {
+				if !indefinite {
+					return nil, fmt.Errorf("Unexpected CBOR break")
+				}
+				break
+			}
+			res = append(res, val)
+		}
+		return res, nil
+	case 5:
+		res := map[string]interface{}{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			k, err := r.readCBOR()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := k.(cborBreak); ok && indefinite {
+				break
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("Map key %v is not a string", k)
+			}
+			if res[key], err = r.readCBOR(); err != nil {
+				return nil, err
+			}
+			if _, ok := res[key].(cborBreak); ok {
+				return nil, fmt.Errorf("Unexpected CBOR break")
+			}
+		}
+		return res, nil
+	case 6:
+		return r.readCBOR()
+	}
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return checkFloat(halfToFloat(uint16(n)))
+	case 26:
+		return checkFloat(float64(math.Float32frombits(uint32(n))))
+	case 27:
+		return checkFloat(math.Float64frombits(n))
+	default:
+		return nil, fmt.Errorf("Unsupported CBOR simple value %d", n)
+	}
+}
+
+// readAll reads a single value with read, and makes sure nothing
+// comes after it.
+func (r *binReader) readAll(read func() (interface{}, error)) (interface{}, error) {
+	res, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := res.(cborBreak); ok {
+		return nil, fmt.Errorf("Unexpected CBOR break")
+	}
+	if r.pos != len(r.buf) {
+		return nil, fmt.Errorf("Unexpected data after the patch")
+	}
+	return res, nil
+}
+
+// patchFromTree turns a decoded array of maps into a Patch.
+func patchFromTree(tree interface{}) (Patch, error) {
+	list, ok := tree.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Patch must be an array")
+	}
+	res := make(Patch, len(list))
+	for i := range list {
+		obj, ok := list[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Operation %d is not a map", i)
+		}
+		op := &res[i]
+		op.Op, _ = obj["op"].(string)
+		op.Value = obj["value"]
+		for k, ptr := range map[string]*Pointer{"path": &op.Path, "from": &op.From} {
+			val, ok := obj[k]
+			if !ok {
+				continue
+			}
+			s, ok := val.(string)
+			if !ok {
+				return nil, fmt.Errorf("Operation %d %v is not a string", i, k)
+			}
+			var err error
+			if *ptr, err = NewPointer(s); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return res, res.validate()
+}
+
+// MarshalMsgpack encodes p as MessagePack, in the same shape it has
+// as JSON.
+func (p Patch) MarshalMsgpack() ([]byte, error) {
+	w := &msgpackWriter{}
+	err := writePatch(w, p)
+	return w.Bytes(), err
+}
+
+// UnmarshalMsgpack decodes a patch encoded by MarshalMsgpack.  Numbers
+// are decoded as float64, just as json.Unmarshal would.
+func (p *Patch) UnmarshalMsgpack(buf []byte) error {
+	r := &binReader{buf: buf}
+	tree, err := r.readAll(r.readMsgpack)
+	if err != nil {
+		return err
+	}
+	res, err := patchFromTree(tree)
+	if err != nil {
+		return err
+	}
+	*p = res
+	return nil
+}
+
+// MarshalCBOR encodes p as CBOR, in the same shape it has as JSON.
+func (p Patch) MarshalCBOR() ([]byte, error) {
+	w := &cborWriter{}
+	err := writePatch(w, p)
+	return w.Bytes(), err
+}
+
+// UnmarshalCBOR decodes a patch encoded by MarshalCBOR.  Numbers
+// are decoded as float64, just as json.Unmarshal would.
+func (p *Patch) UnmarshalCBOR(buf []byte) error {
+	r := &binReader{buf: buf}
+	tree, err := r.readAll(r.readCBOR)
+	if err != nil {
+		return err
+	}
+	res, err := patchFromTree(tree)
+	if err != nil {
+		return err
+	}
+	*p = res
+	return nil
+}
+
+// compactVersion is the version of the compact form MarshalCompact writes.
+const compactVersion = 1
+
+// compactOps are the operations in the order the compact form numbers them.
+var compactOps = []string{"add", "remove", "replace", "move", "copy", "test"}
+
+// compactCode returns the index of op in compactOps, or -1.
+func compactCode(op string) int {
+	for i := range compactOps {
+		if compactOps[i] == op {
+			return i
+		}
+	}
+	return -1
+}
+
+// MarshalCompact encodes p as MessagePack in a form that is smaller
+// than MarshalMsgpack makes, at the cost of no longer looking like
+// JSON.  It is an array of the format version, a table of every
+// pointer segment in p, and the operations.  Each operation is an
+// array of its index in compactOps, its path as indexes into the
+// table, and then its from or value if it has one.
+func (p Patch) MarshalCompact() ([]byte, error) {
+	tokens := []string{}
+	index := map[pointerSegment]int{}
+	for _, op := range p {
+		for _, ptr := range []Pointer{op.Path, op.From} {
+			for _, seg := range ptr {
+				if _, ok := index[seg]; !ok {
+					index[seg] = len(tokens)
+					tokens = append(tokens, string(seg))
+				}
+			}
+		}
+	}
+	w := &msgpackWriter{}
+	w.writeArray(3)
+	w.writeInt(compactVersion)
+	w.writeArray(len(tokens))
+	for _, t := range tokens {
+		w.writeString(t)
+	}
+	writePtr := func(ptr Pointer) {
+		w.writeArray(len(ptr))
+		for _, seg := range ptr {
+			w.writeInt(int64(index[seg]))
+		}
+	}
+	w.writeArray(len(p))
+	for _, op := range p {
+		code := compactCode(op.Op)
+		if code == -1 {
+			return nil, fmt.Errorf("%v is not a valid JSON Patch operator", op.Op)
+		}
+		if op.Op == "remove" {
+			w.writeArray(2)
+		} else {
+			w.writeArray(3)
+		}
+		w.writeInt(int64(code))
+		writePtr(op.Path)
+		switch op.Op {
+		case "move", "copy":
+			writePtr(op.From)
+		case "add", "replace", "test":
+			if err := writeValue(w, op.Value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return w.Bytes(), nil
+}
+
+// UnmarshalCompact decodes a patch encoded by MarshalCompact.
+func (p *Patch) UnmarshalCompact(buf []byte) error {
+	r := &binReader{buf: buf}
+	tree, err := r.readAll(r.readMsgpack)
+	if err != nil {
+		return err
+	}
+	top, ok := tree.([]interface{})
+	if !ok || len(top) != 3 {
+		return fmt.Errorf("Compact patch must be an array of 3 items")
+	}
+	if top[0] != float64(compactVersion) {
+		return fmt.Errorf("Unsupported compact patch version %v", top[0])
+	}
+	rawTokens, ok1 := top[1].([]interface{})
+	ops, ok2 := top[2].([]interface{})
+	if !ok1 || !ok2 {
+		return fmt.Errorf("Compact patch has an invalid token table or operation list")
+	}
+	tokens := make([]pointerSegment, len(rawTokens))
+	for i := range rawTokens {
+		s, ok := rawTokens[i].(string)
+		if !ok {
+			return fmt.Errorf("Token %d is not a string", i)
+		}
+		tokens[i] = pointerSegment(s)
+	}
+	readPtr := func(val interface{}) (Pointer, error) {
+		list, ok := val.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Pointer is not an array")
+		}
+		res := make(Pointer, len(list))
+		for i := range list {
+			idx, ok := list[i].(float64)
+			if !ok || idx < 0 || int(idx) >= len(tokens) || idx != math.Trunc(idx) {
+				return nil, fmt.Errorf("Invalid token %v", list[i])
+			}
+			res[i] = tokens[int(idx)]
+		}
+		return res, nil
+	}
+	res := make(Patch, len(ops))
+	for i := range ops {
+		fields, ok := ops[i].([]interface{})
+		if !ok || len(fields) < 2 {
+			return fmt.Errorf("Operation %d is invalid", i)
+		}
+		code, ok := fields[0].(float64)
+		if !ok || code < 0 || int(code) >= len(compactOps) || code != math.Trunc(code) {
+			return fmt.Errorf("Operation %d has invalid op %v", i, fields[0])
+		}
+		op := &res[i]
+		op.Op = compactOps[int(code)]
+		if op.Path, err = readPtr(fields[1]); err != nil {
+			return err
+		}
+		if len(fields) != 3 {
+			continue
+		}
+		switch op.Op {
+		case "move", "copy":
+			if op.From, err = readPtr(fields[2]); err != nil {
+				return err
+			}
+		default:
+			op.Value = fields[2]
+		}
+	}
+	if err := res.validate(); err != nil {
+		return err
+	}
+	*p = res
+	return nil
+}
@@ -0,0 +1,164 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var binaryPatches = []string{
+	`[]`,
+	`[{"op":"remove","path":"/a"}]`,
+	`[{"op":"add","path":"/a/b~1c/-","value":{"x":[1,-1,-33,200,-200,70000,-70000,5000000000,-5000000000,1.5,-0.25,true,false,null,""]}}]`,
+	`[{"op":"test","path":"","value":"` + strings.Repeat("long ", 20000) + `"},{"op":"move","from":"/a/0","path":"/b/0"},{"op":"copy","from":"/b","path":"/c"}]`,
+	`[{"op":"replace","path":"/ünïcode/~0","value":[` + strings.Repeat(`{"k":1},`, 20) + `{}]}]`,
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	codecs := map[string]struct {
+		marshal   func(Patch) ([]byte, error)
+		unmarshal func(*Patch, []byte) error
+	}{
+		"msgpack": {Patch.MarshalMsgpack, (*Patch).UnmarshalMsgpack},
+		"cbor":    {Patch.MarshalCBOR, (*Patch).UnmarshalCBOR},
+		"compact": {Patch.MarshalCompact, (*Patch).UnmarshalCompact},
+	}
+	for _, raw := range binaryPatches {
+		p, err := NewPatch([]byte(raw))
+		if err != nil {
+			t.Fatalf("Bad test patch %v: %v", raw, err)
+		}
+		expected, _ := json.Marshal(p)
+		for name, codec := range codecs {
+			buf, err := codec.marshal(p)
+			if err != nil {
+				t.Errorf("%v: failed to marshal %.60v: %v", name, raw, err)
+				continue
+			}
+			var res Patch
+			if err := codec.unmarshal(&res, buf); err != nil {
+				t.Errorf("%v: failed to unmarshal %.60v: %v", name, raw, err)
+				continue
+			}
+			got, _ := json.Marshal(res)
+			if string(got) != string(expected) {
+				t.Errorf("%v: round trip of %.60v gave %.60v", name, raw, string(got))
+			}
+			if _, err := codec.marshal(res); err != nil {
+				t.Errorf("%v: failed to remarshal %.60v: %v", name, raw, err)
+			}
+			if len(buf) > 0 {
+				if err := codec.unmarshal(&res, buf[:len(buf)-1]); err == nil {
+					t.Errorf("%v: expected truncated %.60v to fail", name, raw)
+				}
+			}
+			if err := codec.unmarshal(&res, append(buf, 0)); err == nil {
+				t.Errorf("%v: expected trailing data after %.60v to fail", name, raw)
+			}
+		}
+	}
+}
+
+func TestBinaryEncodings(t *testing.T) {
+	p, _ := NewPatch([]byte(`[{"op":"add","path":"/a","value":1}]`))
+	buf, _ := p.MarshalMsgpack()
+	if got := hex.EncodeToString(buf); got != "9183a26f70a3616464a470617468a22f61a576616c756501" {
+		t.Errorf("Unexpected MessagePack %v", got)
+	}
+	buf, _ = p.MarshalCBOR()
+	if got := hex.EncodeToString(buf); got != "81a3626f70636164646470617468622f616576616c756501" {
+		t.Errorf("Unexpected CBOR %v", got)
+	}
+	buf, _ = p.MarshalCompact()
+	if got := hex.EncodeToString(buf); got != "930191a161919300910001" {
+		t.Errorf("Unexpected compact form %v", got)
+	}
+	p = Patch{{Op: "add", Path: Pointer{"a"}, Value: math.NaN()}}
+	if _, err := p.MarshalCBOR(); err == nil {
+		t.Errorf("Expected NaN to be rejected")
+	}
+}
+
+func TestCBORDecoding(t *testing.T) {
+	// Examples from RFC 8949 appendix A, wrapped in an add operation.
+	tests := map[string]string{
+		"f93c00":                     `1`,
+		"f9c400":                     `-4`,
+		"fa47c35000":                 `100000`,
+		"3903e7":                     `-1000`,
+		"9f018202039f0405ffff":       `[1,[2,3],[4,5]]`,
+		"bf61610161629f0203ffff":     `{"a":1,"b":[2,3]}`,
+		"7f657374726561646d696e67ff": `"streaming"`,
+		"c074323031332d30332d32315432303a30343a30305a": `"2013-03-21T20:04:00Z"`,
+	}
+	for value, expected := range tests {
+		raw, _ := hex.DecodeString("81a3626f70636164646470617468622f616576616c7565" + value)
+		var p Patch
+		if err := p.UnmarshalCBOR(raw); err != nil {
+			t.Errorf("Failed to decode %v: %v", value, err)
+			continue
+		}
+		var want interface{}
+		json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(p[0].Value, want) {
+			t.Errorf("Decoding %v: expected %v, got %v", value, expected, p[0].Value)
+		}
+	}
+	for _, bad := range []string{"f97e00", "40", "5f4101ff", "ff", "1c"} {
+		raw, _ := hex.DecodeString("81a3626f70636164646470617468622f616576616c7565" + bad)
+		var p Patch
+		if err := p.UnmarshalCBOR(raw); err == nil {
+			t.Errorf("Expected %v to fail", bad)
+		}
+	}
+}
+
+func TestBinaryDepthLimit(t *testing.T) {
+	// An add operation to /a, wrapped in an array, up to its value.
+	msgpack, _ := hex.DecodeString("9183a26f70a3616464a470617468a22f61a576616c7565")
+	cbor, _ := hex.DecodeString("81a3626f70636164646470617468622f616576616c7565")
+	for _, test := range []struct {
+		name   string
+		prefix []byte
+		header byte
+		decode func(*Patch, []byte) error
+	}{
+		{"MessagePack", msgpack, 0x91, (*Patch).UnmarshalMsgpack},
+		{"CBOR", cbor, 0x81, (*Patch).UnmarshalCBOR},
+		{"CBOR tags", cbor, 0xc0, (*Patch).UnmarshalCBOR},
+	} {
+		raw := append([]byte{}, test.prefix...)
+		raw = append(raw, bytes.Repeat([]byte{test.header}, 100000)...)
+		raw = append(raw, 0xc0)
+		var p Patch
+		err := test.decode(&p, raw)
+		if err == nil || !strings.Contains(err.Error(), "nested") {
+			t.Errorf("%v: expected deep nesting to fail, got %v", test.name, err)
+		}
+		raw = append(append([]byte{}, test.prefix...), bytes.Repeat([]byte{test.header}, 100)...)
+		raw = append(raw, 0x01)
+		if err := test.decode(&p, raw); err != nil {
+			t.Errorf("%v: expected shallow nesting to work, got %v", test.name, err)
+		}
+	}
+}
+
+func TestCompactIsSmaller(t *testing.T) {
+	var base, target interface{}
+	json.Unmarshal([]byte(`{"spec":{"containers":[{"name":"a","image":"x:1"},{"name":"b","image":"y:1"}]}}`), &base)
+	json.Unmarshal([]byte(`{"spec":{"containers":[{"name":"a","image":"x:2"},{"name":"b","image":"y:2"}]}}`), &target)
+	raw, err := GenerateWithOptions(base, target, GenerateOptions{Paranoia: TestLeaves, MergeKeys: map[string]string{"/spec/containers": "name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := NewPatch(raw)
+	full, _ := p.MarshalMsgpack()
+	compact, _ := p.MarshalCompact()
+	if len(compact) >= len(full) || len(full) >= len(raw) {
+		t.Errorf("Expected compact (%d) < msgpack (%d) < JSON (%d)", len(compact), len(full), len(raw))
+	}
+}
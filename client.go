@@ -0,0 +1,141 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// PatchClient sends patches generated from the differences between two
+// versions of a document to a REST API.  If the server rejects a patch
+// with 409 Conflict or 412 Precondition Failed, PatchClient fetches the
+// document again, generates a new patch against it, and resends it.
+type PatchClient struct {
+	// Client is used to make requests.  If nil, http.DefaultClient is used.
+	Client *http.Client
+	// Options controls how patches are generated.  Setting
+	// Options.Paranoia to something other than NoTests makes the server
+	// detect conflicting changes.
+	Options GenerateOptions
+	// Rebase is called with the document the server has after a
+	// conflict, and returns the document we want the server to end
+	// up with.  If nil, the original target is used, which overwrites
+	// whatever the conflicting change did.
+	Rebase func(current interface{}) (interface{}, error)
+	// MaxRetries is how many times a patch will be resent after a
+	// conflict.  If it is 0, a patch will be resent 3 times.  If it
+	// is negative, patches will not be resent.
+	MaxRetries int
+	// Backoff returns how long to wait before resending a patch for
+	// the attempt'th time, starting from 1.  If nil, the delay starts
+	// at 100 milliseconds and doubles every time.
+	Backoff func(attempt int) time.Duration
+}
+
+func (c *PatchClient) client() *http.Client {
+	if c.Client == nil {
+		return http.DefaultClient
+	}
+	return c.Client
+}
+
+func (c *PatchClient) backoff(attempt int) time.Duration {
+	if c.Backoff == nil {
+		return (100 * time.Millisecond) << uint(attempt-1)
+	}
+	return c.Backoff(attempt)
+}
+
+// failed turns a response we did not want into an *HTTPError.
+func failed(resp *http.Response) error {
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	return &HTTPError{Status: resp.StatusCode, Message: string(bytes.TrimSpace(msg))}
+}
+
+// fetch GETs the current document at url along with its ETag.
+func (c *PatchClient) fetch(ctx context.Context, url string) (interface{}, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", failed(resp)
+	}
+	defer resp.Body.Close()
+	var doc interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, "", fmt.Errorf("Cannot decode %v: %v", url, err)
+	}
+	return doc, resp.Header.Get("ETag"), nil
+}
+
+// send PATCHes url with the differences between base and target.
+func (c *PatchClient) send(ctx context.Context, url string, base, target interface{}, etag string) (*http.Response, error) {
+	p, err := GenerateWithOptions(base, target, c.Options)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ContentType)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	return c.client().Do(req)
+}
+
+// Patch sends a PATCH to url that turns base into target.  If the
+// server reports a conflict, Patch fetches the current document with a
+// GET to url, generates a new patch against it, and tries again, using
+// the ETag of the fetched document for If-Match.
+//
+// Patch returns the response of the first successful PATCH, which the
+// caller must close.  Any other response is returned as an *HTTPError.
+func (c *PatchClient) Patch(ctx context.Context, url string, base, target interface{}) (*http.Response, error) {
+	maxRetries := c.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	}
+	etag := ""
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, url, base, target, etag)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+		err = failed(resp)
+		if (resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusPreconditionFailed) ||
+			attempt >= maxRetries {
+			return nil, err
+		}
+		timer := time.NewTimer(c.backoff(attempt + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if base, etag, err = c.fetch(ctx, url); err != nil {
+			return nil, err
+		}
+		if c.Rebase != nil {
+			if target, err = c.Rebase(base); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package jsonpatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves a single document with PatchHandler, and changes
+// it behind the client's back the first conflicts times it is patched.
+type testServer struct {
+	sync.Mutex
+	doc       interface{}
+	version   int
+	conflicts int
+	patches   int
+}
+
+func (s *testServer) etag() string {
+	return fmt.Sprintf(`"%d"`, s.version)
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	if r.Method == http.MethodGet {
+		w.Header().Set("ETag", s.etag())
+		json.NewEncoder(w).Encode(s.doc)
+		return
+	}
+	s.patches++
+	if s.conflicts > 0 {
+		s.conflicts--
+		s.doc.(map[string]interface{})["a"] = float64(-s.patches)
+		s.doc.(map[string]interface{})["other"] = float64(s.patches)
+		s.version++
+	}
+	h := &PatchHandler{
+		Load: func(r *http.Request) (interface{}, string, error) {
+			return s.doc, s.etag(), nil
+		},
+		Save: func(r *http.Request, doc interface{}, etag string) (string, error) {
+			s.doc = doc
+			s.version++
+			return s.etag(), nil
+		},
+	}
+	h.ServeHTTP(w, r)
+}
+
+func TestPatchClient(t *testing.T) {
+	for _, conflicts := range []int{0, 1, 2, 5} {
+		var base, target interface{}
+		json.Unmarshal([]byte(`{"a":1,"b":[1,2],"other":0}`), &base)
+		json.Unmarshal([]byte(`{"a":2,"b":[1,2,3],"other":0}`), &target)
+		s := &testServer{doc: base, conflicts: conflicts}
+		srv := httptest.NewServer(s)
+		c := &PatchClient{
+			Options: GenerateOptions{Paranoia: TestLeaves},
+			Backoff: func(int) time.Duration { return time.Millisecond },
+			Rebase: func(current interface{}) (interface{}, error) {
+				res, err, _ := Apply(current, []byte(`[{"op":"replace","path":"/a","value":2},{"op":"add","path":"/b/-","value":3}]`))
+				return res, err
+			},
+		}
+		resp, err := c.Patch(context.Background(), srv.URL, base, target)
+		srv.Close()
+		if conflicts > 3 {
+			if e, ok := err.(*HTTPError); !ok || e.Status != http.StatusPreconditionFailed {
+				t.Errorf("%d conflicts: expected a 412 error, got %v", conflicts, err)
+			}
+			if s.patches != 4 {
+				t.Errorf("%d conflicts: expected 4 attempts, got %d", conflicts, s.patches)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d conflicts: %v", conflicts, err)
+			continue
+		}
+		resp.Body.Close()
+		var expected interface{}
+		json.Unmarshal([]byte(fmt.Sprintf(`{"a":2,"b":[1,2,3],"other":%d}`, conflicts)), &expected)
+		if !reflect.DeepEqual(expected, s.doc) {
+			t.Errorf("%d conflicts: expected %v, got %v", conflicts, expected, s.doc)
+		}
+		if s.patches != conflicts+1 {
+			t.Errorf("%d conflicts: expected %d attempts, got %d", conflicts, conflicts+1, s.patches)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// MergeContentType is the media type of a JSON Merge Patch as defined
+// in RFC 7386.
+const MergeContentType = "application/merge-patch+json"
+
+// stripNulls returns a copy of val with all null object members
+// removed, which is what merging val into nothing would produce.
+func stripNulls(val interface{}) interface{} {
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return utils.Clone(val)
+	}
+	res := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if v != nil {
+			res[k] = stripNulls(v)
+		}
+	}
+	return res
+}
+
+// mergeOps translates the merge patch m into operations against doc at ptr.
+func mergeOps(doc, m interface{}, ptr Pointer) Patch {
+	res := make(Patch, 0)
+	mObj, ok := m.(map[string]interface{})
+	if !ok {
+		if !reflect.DeepEqual(doc, m) {
+			res = append(res, Operation{"replace", ptr, nil, utils.Clone(m)})
+		}
+		return res
+	}
+	docObj, ok := doc.(map[string]interface{})
+	if !ok {
+		return append(res, Operation{"replace", ptr, nil, stripNulls(mObj)})
+	}
+	for _, k := range sortedKeys(mObj) {
+		v := mObj[k]
+		old, exists := docObj[k]
+		switch {
+		case v == nil:
+			if exists {
+				res = append(res, Operation{"remove", ptr.Append(k), nil, nil})
+			}
+		case !exists:
+			res = append(res, Operation{"add", ptr.Append(k), nil, stripNulls(v)})
+		default:
+			res = append(res, mergeOps(old, v, ptr.Append(k))...)
+		}
+	}
+	return res
+}
+
+// MergeToJSONPatch translates merge, a JSON Merge Patch as defined in
+// RFC 7386, into a JSON Patch that has the same effect on doc.
+//
+// doc and merge must be the result of unmarshalling JSON into an interface{}
+func MergeToJSONPatch(doc, merge interface{}) ([]byte, error) {
+	return json.Marshal(mergeOps(doc, merge, make(Pointer, 0)))
+}
+
+// hasNull returns true if val contains a null anywhere.
+func hasNull(val interface{}) bool {
+	switch t := val.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		for _, v := range t {
+			if hasNull(v) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, v := range t {
+			if hasNull(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// JSONPatchToMerge translates rawPatch, which must be a valid JSON
+// Patch, into an equivalent JSON Merge Patch.  Not every JSON Patch can
+// be expressed as a merge patch, and since there is no document to look
+// at the translation has to be conservative.  It will return an error
+// saying why if the patch contains:
+//
+//   - test, move, or copy ops,
+//   - paths that may refer to array elements, which is any path with a
+//     segment that is `-` or an array index,
+//   - values that contain nulls, because a merge patch would delete the
+//     members instead of setting them to null,
+//   - replace ops with object values, because a merge patch would keep
+//     the members of the original object that are not in the value,
+//   - ops on the whole document, unless it is a single replace of the
+//     document with something that is not an object.
+//
+// Add ops with object values are assumed to be adding new members.
+func JSONPatchToMerge(rawPatch []byte) ([]byte, error) {
+	p, err := NewPatch(rawPatch)
+	if err != nil {
+		return nil, err
+	}
+	var res interface{} = map[string]interface{}{}
+	for i, op := range p {
+		switch op.Op {
+		case "add", "replace", "remove":
+		default:
+			return nil, fmt.Errorf("Op %v: %v ops cannot be expressed as a merge patch", i, op.Op)
+		}
+		if op.Op != "remove" && hasNull(op.Value) {
+			return nil, fmt.Errorf("Op %v: the value for %v contains a null, which a merge patch would treat as a deletion", i, op.Path.String())
+		}
+		if len(op.Path) == 0 {
+			if _, isObj := op.Value.(map[string]interface{}); len(p) == 1 && op.Op == "replace" && !isObj {
+				return json.Marshal(op.Value)
+			}
+			return nil, fmt.Errorf("Op %v: %v of the whole document cannot be expressed as a merge patch", i, op.Op)
+		}
+		if _, isObj := op.Value.(map[string]interface{}); op.Op == "replace" && isObj {
+			return nil, fmt.Errorf("Op %v: replacing %v with an object cannot be expressed as a merge patch", i, op.Path.String())
+		}
+		for _, seg := range op.Path {
+			if _, err := strconv.Atoi(string(seg)); err == nil || seg == "-" {
+				return nil, fmt.Errorf("Op %v: %v may refer to an array element, which a merge patch cannot address", i, op.Path.String())
+			}
+		}
+		container := res.(map[string]interface{})
+		last, parent := op.Path.Chop()
+		for _, seg := range parent {
+			next, ok := container[string(seg)]
+			if !ok || next == nil {
+				next = map[string]interface{}{}
+				container[string(seg)] = next
+			}
+			obj, ok := next.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Op %v: %v is inside a value that is not an object", i, op.Path.String())
+			}
+			container = obj
+		}
+		if op.Op == "remove" {
+			container[last] = nil
+		} else {
+			container[last] = utils.Clone(op.Value)
+		}
+	}
+	return json.Marshal(res)
+}
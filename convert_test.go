@@ -0,0 +1,116 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type mergeToPatchTest struct {
+	doc    string
+	merge  string
+	patch  string
+	result string
+}
+
+var mergeToPatchTests = []mergeToPatchTest{
+	{
+		`{"a":"b","c":{"d":"e","f":"g"}}`,
+		`{"a":"z","c":{"f":null}}`,
+		`[{"op":"replace","path":"/a","value":"z"},{"op":"remove","path":"/c/f"}]`,
+		`{"a":"z","c":{"d":"e"}}`,
+	},
+	{
+		`{"a":[1,2],"b":1}`,
+		`{"a":[3],"b":1,"c":{"d":null,"e":1},"x":null}`,
+		`[{"op":"replace","path":"/a","value":[3]},{"op":"add","path":"/c","value":{"e":1}}]`,
+		`{"a":[3],"b":1,"c":{"e":1}}`,
+	},
+	{
+		`{"a":1}`,
+		`{"a":{"b":null,"c":2}}`,
+		`[{"op":"replace","path":"/a","value":{"c":2}}]`,
+		`{"a":{"c":2}}`,
+	},
+	{
+		`{"a":1}`,
+		`["x"]`,
+		`[{"op":"replace","path":"","value":["x"]}]`,
+		`["x"]`,
+	},
+}
+
+func TestMergeToJSONPatch(t *testing.T) {
+	for _, test := range mergeToPatchTests {
+		var doc, merge interface{}
+		json.Unmarshal([]byte(test.doc), &doc)
+		json.Unmarshal([]byte(test.merge), &merge)
+		p, err := MergeToJSONPatch(doc, merge)
+		if err != nil {
+			t.Errorf("Failed to translate `%v` (%v)", test.merge, err)
+			continue
+		}
+		if string(p) != test.patch {
+			t.Errorf("Translated `%v` into `%v`, not `%v`", test.merge, string(p), test.patch)
+			continue
+		}
+		patched, err, _ := Apply(doc, p)
+		if err != nil {
+			t.Errorf("Failed to apply translated patch `%v` (%v)", string(p), err)
+			continue
+		}
+		var expected interface{}
+		json.Unmarshal([]byte(test.result), &expected)
+		if !reflect.DeepEqual(patched, expected) {
+			t.Errorf("Translated patch `%v` gave %#v, not %v", string(p), patched, test.result)
+		}
+	}
+}
+
+type patchToMergeTest struct {
+	patch string
+	merge string
+	valid bool
+}
+
+var patchToMergeTests = []patchToMergeTest{
+	{
+		`[{"op":"replace","path":"/a","value":"z"},{"op":"remove","path":"/c/f"},{"op":"add","path":"/c/g","value":{"h":[1]}}]`,
+		`{"a":"z","c":{"f":null,"g":{"h":[1]}}}`,
+		true,
+	},
+	{
+		`[{"op":"add","path":"/a","value":{"b":1}},{"op":"replace","path":"/a/b","value":2}]`,
+		`{"a":{"b":2}}`,
+		true,
+	},
+	{`[{"op":"replace","path":"","value":5}]`, `5`, true},
+	{`[{"op":"test","path":"/a","value":5}]`, ``, false},
+	{`[{"op":"move","from":"/a","path":"/b"}]`, ``, false},
+	{`[{"op":"copy","from":"/a","path":"/b"}]`, ``, false},
+	{`[{"op":"add","path":"/a/0","value":5}]`, ``, false},
+	{`[{"op":"add","path":"/a/-","value":5}]`, ``, false},
+	{`[{"op":"add","path":"/a","value":{"b":null}}]`, ``, false},
+	{`[{"op":"replace","path":"/a","value":{"b":1}}]`, ``, false},
+	{`[{"op":"replace","path":"","value":{"b":1}}]`, ``, false},
+	{`[{"op":"add","path":"/a","value":1},{"op":"add","path":"/a/b","value":1}]`, ``, false},
+}
+
+func TestJSONPatchToMerge(t *testing.T) {
+	for _, test := range patchToMergeTests {
+		res, err := JSONPatchToMerge([]byte(test.patch))
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Expected translating `%v` to fail, got `%v`", test.patch, string(res))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to translate `%v` (%v)", test.patch, err)
+			continue
+		}
+		if string(res) != test.merge {
+			t.Errorf("Translated `%v` into `%v`, not `%v`", test.patch, string(res), test.merge)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EqualOptions controls how Equal compares values.  The zero value
+// compares values exactly, except that the order of keys in a
+// *utils.Object does not matter.
+type EqualOptions struct {
+	// Numeric makes numbers equal if they have the same value, no
+	// matter what Go type holds them, so int(1), float64(1), and
+	// json.Number("1") are all equal.  Typed maps, slices, and structs
+	// are compared as the JSON they marshal to.
+	Numeric bool
+	// UnorderedArrays holds pointers, which may use `*` to match any
+	// single segment, to arrays whose elements can be in any order.
+	// Patterns that are not valid pointers never match.
+	UnorderedArrays []string
+	// IgnoreCase compares strings without regard to case.
+	IgnoreCase bool
+	// Tolerance is how far apart numbers can be and still be equal.
+	// Setting it implies Numeric.
+	Tolerance float64
+}
+
+// comparer compares values as directed by EqualOptions.
+type comparer struct {
+	opts      EqualOptions
+	unordered []pattern
+}
+
+// newComparer returns a comparer for opts, and an error for the first
+// pattern in opts.UnorderedArrays that is not valid.  Valid patterns
+// are used either way.
+func newComparer(opts EqualOptions) (*comparer, error) {
+	res := &comparer{opts: opts}
+	var firstErr error
+	for _, s := range opts.UnorderedArrays {
+		pat, err := newPattern(s)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		res.unordered = append(res.unordered, pat)
+	}
+	return res, firstErr
+}
+
+func (c *comparer) numeric() bool {
+	return c.opts.Numeric || c.opts.Tolerance > 0
+}
+
+// toFloat returns the value of val if it is a number.
+func toFloat(val interface{}) (float64, bool) {
+	switch t := val.(type) {
+	case float64:
+		return t, true
+	case json.Number:
+		f, err := t.Float64()
+		return f, err == nil
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// plainJSON turns typed containers into the unmarshalled JSON they
+// stand for, so that they can be compared with unmarshalled JSON.
+func plainJSON(val interface{}) interface{} {
+	switch val.(type) {
+	case nil, bool, float64, string, json.Number, []interface{}, map[string]interface{}:
+		return val
+	}
+	switch reflect.ValueOf(val).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Ptr, reflect.Interface:
+		buf, err := json.Marshal(val)
+		if err != nil {
+			return val
+		}
+		var res interface{}
+		if json.Unmarshal(buf, &res) != nil {
+			return val
+		}
+		return res
+	}
+	return val
+}
+
+// isUnordered returns true if the array at ptr can be in any order.
+func (c *comparer) isUnordered(ptr Pointer) bool {
+	for _, pat := range c.unordered {
+		if pat.Matches(ptr) {
+			return true
+		}
+	}
+	return false
+}
+
+// child returns the pointer to the member of ptr named by key.  It
+// only bothers if there are patterns to match against.
+func (c *comparer) child(ptr Pointer, key string) Pointer {
+	if len(c.unordered) == 0 {
+		return ptr
+	}
+	return ptr.Append(key)
+}
+
+// matchAll returns true if every element of a can be paired with a
+// different element of b that it is equal to.  With Tolerance or
+// IgnoreCase, equality is not transitive, so taking the first match
+// for each element is not good enough: an element may need to give up
+// its match to one that has no other choice.  This finds the pairs by
+// looking for augmenting paths, the usual way to find a maximum
+// bipartite matching.
+func (c *comparer) matchAll(a, b []interface{}, ptr Pointer) bool {
+	matches := make([][]bool, len(a))
+	for i := range a {
+		elemPtr := c.child(ptr, strconv.Itoa(i))
+		matches[i] = make([]bool, len(b))
+		for j := range b {
+			matches[i][j] = c.equal(a[i], b[j], elemPtr)
+		}
+	}
+	pairedWith := make([]int, len(b))
+	for j := range pairedWith {
+		pairedWith[j] = -1
+	}
+	var augment func(i int, tried []bool) bool
+	augment = func(i int, tried []bool) bool {
+		for j := range b {
+			if !matches[i][j] || tried[j] {
+				continue
+			}
+			tried[j] = true
+			if pairedWith[j] == -1 || augment(pairedWith[j], tried) {
+				pairedWith[j] = i
+				return true
+			}
+		}
+		return false
+	}
+	for i := range a {
+		if !augment(i, make([]bool, len(b))) {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares a and b, which are at ptr in their documents.
+func (c *comparer) equal(a, b interface{}, ptr Pointer) bool {
+	a, b = unordered(a), unordered(b)
+	if c.numeric() {
+		a, b = plainJSON(a), plainJSON(b)
+	}
+	switch at := a.(type) {
+	case map[string]interface{}:
+		bt, ok := b.(map[string]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		for k, v := range at {
+			if bv, ok := bt[k]; !ok || !c.equal(v, bv, c.child(ptr, k)) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		if !ok || len(at) != len(bt) {
+			return false
+		}
+		if !c.isUnordered(ptr) {
+			for i := range at {
+				if !c.equal(at[i], bt[i], c.child(ptr, strconv.Itoa(i))) {
+					return false
+				}
+			}
+			return true
+		}
+		return c.matchAll(at, bt, ptr)
+	case string:
+		bt, ok := b.(string)
+		if ok && c.opts.IgnoreCase {
+			return strings.EqualFold(at, bt)
+		}
+		return ok && at == bt
+	}
+	if c.numeric() {
+		af, ok1 := toFloat(a)
+		bf, ok2 := toFloat(b)
+		if ok1 && ok2 {
+			return af == bf || math.Abs(af-bf) <= c.opts.Tolerance
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Equal returns true if a and b are the same JSON value, as compared
+// according to opts.  a and b can be unmarshalled JSON, or anything
+// else a Pointer can address.
+func Equal(a, b interface{}, opts EqualOptions) bool {
+	c, _ := newComparer(opts)
+	return c.equal(a, b, make(Pointer, 0))
+}
+
+// equal compares a and b with the default options.
+func equal(a, b interface{}) bool {
+	return Equal(a, b, EqualOptions{})
+}
@@ -0,0 +1,115 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+type equalTest struct {
+	a, b     interface{}
+	opts     EqualOptions
+	expected bool
+}
+
+func jsonVal(s string) interface{} {
+	var res interface{}
+	if err := json.Unmarshal([]byte(s), &res); err != nil {
+		panic(err)
+	}
+	return res
+}
+
+func orderedVal(s string) interface{} {
+	res, err := utils.UnmarshalOrdered([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+var equalTests = []equalTest{
+	{jsonVal(`{"a":[1,"b",null]}`), jsonVal(`{"a":[1,"b",null]}`), EqualOptions{}, true},
+	{jsonVal(`{"a":1}`), orderedVal(`{"a":1}`), EqualOptions{}, true},
+	{orderedVal(`{"a":1,"b":2}`), orderedVal(`{"b":2,"a":1}`), EqualOptions{}, true},
+	{1, 1.0, EqualOptions{}, false},
+	{1, 1.0, EqualOptions{Numeric: true}, true},
+	{int64(2), json.Number("2"), EqualOptions{Numeric: true}, true},
+	{uint8(2), float32(2.5), EqualOptions{Numeric: true}, false},
+	{[]int{1, 2}, jsonVal(`[1,2]`), EqualOptions{}, false},
+	{[]int{1, 2}, jsonVal(`[1,2]`), EqualOptions{Numeric: true}, true},
+	{struct {
+		A int `json:"a"`
+	}{1}, jsonVal(`{"a":1}`), EqualOptions{Numeric: true}, true},
+	{"Hello", "hello", EqualOptions{}, false},
+	{"Hello", "hELLO", EqualOptions{IgnoreCase: true}, true},
+	{jsonVal(`{"Hello":1}`), jsonVal(`{"hello":1}`), EqualOptions{IgnoreCase: true}, false},
+	{1.0, 1.05, EqualOptions{Tolerance: 0.1}, true},
+	{1.0, 1.2, EqualOptions{Tolerance: 0.1}, false},
+	{2, 2.01, EqualOptions{Tolerance: 0.1}, true},
+	{jsonVal(`[1,2,3]`), jsonVal(`[3,1,2]`), EqualOptions{}, false},
+	{jsonVal(`[1,2,3]`), jsonVal(`[3,1,2]`), EqualOptions{UnorderedArrays: []string{""}}, true},
+	{jsonVal(`[1,2,2]`), jsonVal(`[2,1,1]`), EqualOptions{UnorderedArrays: []string{""}}, false},
+	{jsonVal(`[1,2]`), jsonVal(`[2,0.5]`), EqualOptions{UnorderedArrays: []string{""}, Tolerance: 1}, true},
+	{jsonVal(`["a","A"]`), jsonVal(`["A","b"]`), EqualOptions{UnorderedArrays: []string{""}, IgnoreCase: true}, false},
+	{jsonVal(`{"a":{"tags":["x","y"]},"b":{"tags":["x","y"]}}`), jsonVal(`{"a":{"tags":["y","x"]},"b":{"tags":["y","x"]}}`), EqualOptions{UnorderedArrays: []string{"/*/tags"}}, true},
+	{jsonVal(`{"a":{"tags":["x","y"]},"b":{"tags":["x","y"]}}`), jsonVal(`{"a":{"tags":["y","x"]},"b":{"tags":["y","x"]}}`), EqualOptions{UnorderedArrays: []string{"/a/tags"}}, false},
+	{jsonVal(`[{"n":["a","B"]},{"n":["c"]}]`), jsonVal(`[{"n":["C"]},{"n":["b","A"]}]`), EqualOptions{UnorderedArrays: []string{"", "/*/n"}, IgnoreCase: true}, true},
+}
+
+func TestEqual(t *testing.T) {
+	for i, test := range equalTests {
+		if got := Equal(test.a, test.b, test.opts); got != test.expected {
+			t.Errorf("Test %d: Equal(%v, %v, %+v) = %v", i, test.a, test.b, test.opts, got)
+		}
+		if got := Equal(test.b, test.a, test.opts); got != test.expected {
+			t.Errorf("Test %d: Equal(%v, %v, %+v) = %v", i, test.b, test.a, test.opts, got)
+		}
+	}
+}
+
+func TestApplyEqualOptions(t *testing.T) {
+	doc := struct {
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+	}{3, []string{"a", "b"}}
+	p := []byte(`[{"op":"test","path":"/count","value":3},{"op":"test","path":"/tags","value":["B","A"]}]`)
+	if _, err, _ := ApplyWithOptions(doc, p, ApplyOptions{}); err == nil {
+		t.Errorf("Expected the test to fail with the default options")
+	}
+	opts := ApplyOptions{Equal: EqualOptions{Numeric: true, IgnoreCase: true, UnorderedArrays: []string{"/tags"}}}
+	if _, err, _ := ApplyWithOptions(doc, p, opts); err != nil {
+		t.Errorf("Expected the test to pass, got %v", err)
+	}
+	opts.Equal.UnorderedArrays = []string{"~"}
+	if _, err, _ := ApplyWithOptions(doc, p, opts); err == nil {
+		t.Errorf("Expected an invalid pattern to be rejected")
+	}
+}
+
+func TestGenerateEqualOptions(t *testing.T) {
+	base := `{"name":"Thing","tags":["a","b"],"size":1.0}`
+	target := `{"name":"thing","tags":["b","a"],"size":1.001}`
+	tests := []struct {
+		opts     EqualOptions
+		expected string
+	}{
+		{EqualOptions{}, `[{"op":"replace","path":"/name","value":"thing"},{"op":"replace","path":"/size","value":1.001},{"op":"replace","path":"/tags","value":["b","a"]}]`},
+		{EqualOptions{IgnoreCase: true, Tolerance: 0.01}, `[{"op":"replace","path":"/tags","value":["b","a"]}]`},
+		{EqualOptions{IgnoreCase: true, Tolerance: 0.01, UnorderedArrays: []string{"/tags"}}, `[]`},
+	}
+	for _, test := range tests {
+		p, err := GenerateJSONWithOptions([]byte(base), []byte(target), GenerateOptions{Equal: test.opts})
+		if err != nil {
+			t.Errorf("Failed to generate with %+v: %v", test.opts, err)
+			continue
+		}
+		if string(p) != test.expected {
+			t.Errorf("With %+v: expected %v, got %v", test.opts, test.expected, string(p))
+		}
+	}
+	if _, err := GenerateJSONWithOptions([]byte(base), []byte(target), GenerateOptions{Equal: EqualOptions{UnorderedArrays: []string{"x"}}}); err == nil {
+		t.Errorf("Expected an invalid pattern to be rejected")
+	}
+}
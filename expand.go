@@ -0,0 +1,188 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// patternOp is a patch operation whose path may select more than one
+// location.  It is expanded into one operation per location.
+type patternOp struct {
+	Op    string           `json:"op"`
+	Path  string           `json:"path"`
+	From  *string          `json:"from"`
+	Value *json.RawMessage `json:"value"`
+}
+
+// expand turns o into concrete operations against doc.
+//
+// test, replace, and remove apply to every location the path selects.
+// add and copy apply to the new member or element named by the last
+// step of the path in every container the rest of the path selects,
+// so that `/items/*/enabled` can add a member to every item.  move must
+// select exactly one location, since a value can only be moved once.
+// from is always a plain JSON pointer.
+func (o *patternOp) expand(doc interface{}) (Patch, error) {
+	res := make(Patch, 0)
+	op := Operation{Op: o.Op}
+	switch o.Op {
+	case "test", "replace", "add":
+		if o.Value == nil {
+			return nil, fmt.Errorf("%v must have a valid value", o.Op)
+		}
+		if err := json.Unmarshal(*o.Value, &op.Value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		if o.From == nil {
+			return nil, fmt.Errorf("%v must have a from", o.Op)
+		}
+		from, err := NewPointer(*o.From)
+		if err != nil {
+			return nil, err
+		}
+		op.From = from
+	case "remove":
+	default:
+		return nil, fmt.Errorf("%v is not a valid JSON Patch operator", o.Op)
+	}
+	path, err := newSelectorPath(o.Path)
+	if err != nil {
+		return nil, err
+	}
+	var targets []Pointer
+	switch o.Op {
+	case "test", "replace", "remove":
+		for _, m := range path.find(doc) {
+			targets = append(targets, m.ptr)
+		}
+	default:
+		if len(path) == 0 {
+			targets = append(targets, make(Pointer, 0))
+			break
+		}
+		last, ok := path[len(path)-1].(childSel)
+		if !ok || len(last) != 1 {
+			return nil, fmt.Errorf("The last step of `%v` must name a single location for %v", o.Path, o.Op)
+		}
+		for _, m := range path[:len(path)-1].find(doc) {
+			targets = append(targets, m.ptr.Append(last[0]))
+		}
+	}
+	if o.Op == "move" && len(targets) != 1 {
+		return nil, fmt.Errorf("`%v` must select exactly one location for move, not %v", o.Path, len(targets))
+	}
+	if o.Op == "remove" {
+		// Work from the end of the document towards the start, so
+		// that removing array elements does not shift the indexes
+		// of the ones still to be removed.  Selectors like [2,0]
+		// can find targets in any order, so sort them, and drop
+		// any found twice so they are not removed twice.
+		sort.SliceStable(targets, func(i, j int) bool {
+			return comparePointers(targets[i], targets[j]) > 0
+		})
+		uniq := targets[:0]
+		for i := range targets {
+			if i == 0 || !targets[i].Equal(targets[i-1]) {
+				uniq = append(uniq, targets[i])
+			}
+		}
+		targets = uniq
+	}
+	for _, target := range targets {
+		res = append(res, Operation{op.Op, target, op.From, utils.Clone(op.Value)})
+	}
+	return res, nil
+}
+
+// comparePointers orders pointers by document position, comparing
+// segments that are both array indexes as numbers.  A pointer sorts
+// after the pointers to its ancestors.
+func comparePointers(a, b Pointer) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		ai, aErr := strconv.Atoi(string(a[i]))
+		bi, bErr := strconv.Atoi(string(b[i]))
+		if aErr == nil && bErr == nil {
+			if ai < bi {
+				return -1
+			}
+			return 1
+		}
+		if a[i] < b[i] {
+			return -1
+		}
+		return 1
+	}
+	return len(a) - len(b)
+}
+
+// expandPatch parses rawPatch as a patch whose paths may be patterns,
+// and expands it against base one operation at a time.  Each operation
+// is expanded against the result of applying everything before it.  If
+// err is returned, loc is the index of the operation in rawPatch that
+// failed.
+func expandPatch(base interface{}, rawPatch []byte) (res Patch, result interface{}, err error, loc int) {
+	ops := make([]patternOp, 0)
+	if err = json.Unmarshal(rawPatch, &ops); err != nil {
+		return nil, nil, err, 0
+	}
+	res = make(Patch, 0)
+	result = utils.Clone(base)
+	for i := range ops {
+		expanded, err := ops[i].expand(result)
+		if err != nil {
+			return nil, result, err, i
+		}
+		for _, op := range expanded {
+			// Keep the document from sharing values with the patch.
+			op.Value = utils.Clone(op.Value)
+			result, err = op.Apply(result)
+			if err != nil {
+				return nil, result, err, i
+			}
+		}
+		res = append(res, expanded...)
+	}
+	return res, result, nil, 0
+}
+
+// Expand takes a patch whose paths may select more than one location
+// and expands it against doc into a plain JSON Patch.  A path that
+// starts with `$` is a JSONPath expression; any other path is a JSON
+// pointer in which a segment that is just `*` matches every member or
+// element.  So
+//
+//	[{"op":"replace","path":"/items/*/enabled","value":false}]
+//
+// and
+//
+//	[{"op":"replace","path":"$.items[*].enabled","value":false}]
+//
+// both turn into a replace op for every item that has an enabled
+// member.  A path that selects nothing expands to no operations.
+//
+// doc must be the result of unmarshalling JSON into an interface{}, and
+// will not be modified.
+func Expand(doc interface{}, rawPatch []byte) ([]byte, error) {
+	p, _, err, loc := expandPatch(doc, rawPatch)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to expand operation %v: %v", loc, err)
+	}
+	return json.Marshal(p)
+}
+
+// ApplyExpanded does the same thing as Apply, except rawPatch may
+// contain paths that select more than one location, as with Expand.
+// If err is returned, loc is the index of the operation in rawPatch
+// that failed.
+func ApplyExpanded(base interface{}, rawPatch []byte) (result interface{}, err error, loc int) {
+	_, result, err, loc = expandPatch(base, rawPatch)
+	return result, err, loc
+}
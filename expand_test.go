@@ -0,0 +1,121 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type expandTest struct {
+	desc     string
+	src      string
+	patch    string
+	expanded string
+	final    string
+}
+
+var expandTests = []expandTest{
+	{
+		`Replace every match`,
+		`{"items":[{"enabled":true},{"enabled":true},{"other":1}]}`,
+		`[{"op":"replace","path":"/items/*/enabled","value":false}]`,
+		`[{"op":"replace","path":"/items/0/enabled","value":false},{"op":"replace","path":"/items/1/enabled","value":false}]`,
+		`{"items":[{"enabled":false},{"enabled":false},{"other":1}]}`,
+	},
+	{
+		`Add to every container`,
+		`{"items":[{"a":1},{"a":2}]}`,
+		`[{"op":"add","path":"$.items[*].enabled","value":true}]`,
+		`[{"op":"add","path":"/items/0/enabled","value":true},{"op":"add","path":"/items/1/enabled","value":true}]`,
+		`{"items":[{"a":1,"enabled":true},{"a":2,"enabled":true}]}`,
+	},
+	{
+		`Remove works back to front`,
+		`{"items":[1,2,3,4]}`,
+		`[{"op":"remove","path":"/items/*"}]`,
+		`[{"op":"remove","path":"/items/3"},{"op":"remove","path":"/items/2"},{"op":"remove","path":"/items/1"},{"op":"remove","path":"/items/0"}]`,
+		`{"items":[]}`,
+	},
+	{
+		`Remove sorts indexes selected out of order`,
+		`{"items":[0,1,2,3,4,5,6,7,8,9,10],"other":[1,2]}`,
+		`[{"op":"remove","path":"$.items[2,10,0,2]"}]`,
+		`[{"op":"remove","path":"/items/10"},{"op":"remove","path":"/items/2"},{"op":"remove","path":"/items/0"}]`,
+		`{"items":[1,3,4,5,6,7,8,9],"other":[1,2]}`,
+	},
+	{
+		`Remove with a filter`,
+		`{"items":[{"n":1},{"n":5},{"n":2},{"n":7}]}`,
+		`[{"op":"remove","path":"$.items[?(@.n > 3)]"}]`,
+		`[{"op":"remove","path":"/items/3"},{"op":"remove","path":"/items/1"}]`,
+		`{"items":[{"n":1},{"n":2}]}`,
+	},
+	{
+		`Later ops see earlier ones`,
+		`{"items":[{"a":1}]}`,
+		`[{"op":"add","path":"/items/-","value":{"a":2}},{"op":"replace","path":"/items/*/a","value":0}]`,
+		`[{"op":"add","path":"/items/-","value":{"a":2}},{"op":"replace","path":"/items/0/a","value":0},{"op":"replace","path":"/items/1/a","value":0}]`,
+		`{"items":[{"a":0},{"a":0}]}`,
+	},
+	{
+		`Copy to every container`,
+		`{"default":1,"items":[{},{}]}`,
+		`[{"op":"copy","from":"/default","path":"/items/*/v"}]`,
+		`[{"from":"/default","op":"copy","path":"/items/0/v"},{"from":"/default","op":"copy","path":"/items/1/v"}]`,
+		`{"default":1,"items":[{"v":1},{"v":1}]}`,
+	},
+	{
+		`Nothing selected`,
+		`{"items":[]}`,
+		`[{"op":"remove","path":"/items/*/enabled"}]`,
+		`[]`,
+		`{"items":[]}`,
+	},
+}
+
+func TestExpand(t *testing.T) {
+	for _, test := range expandTests {
+		t.Log(test.desc)
+		var src, final interface{}
+		json.Unmarshal([]byte(test.src), &src)
+		json.Unmarshal([]byte(test.final), &final)
+		res, err := Expand(src, []byte(test.patch))
+		if err != nil {
+			t.Errorf("Failed to expand `%v` (%v)", test.patch, err)
+			continue
+		}
+		if string(res) != test.expanded {
+			t.Errorf("Expanded `%v` into `%v`, not `%v`", test.patch, string(res), test.expanded)
+		}
+		patched, err, idx := ApplyExpanded(src, []byte(test.patch))
+		if err != nil {
+			t.Errorf("Failed to apply `%v` at operation %v (%v)", test.patch, idx, err)
+			continue
+		}
+		if !reflect.DeepEqual(patched, final) {
+			actual, _ := json.Marshal(patched)
+			t.Errorf("Applying `%v` gave `%v`, not `%v`", test.patch, string(actual), test.final)
+		}
+	}
+}
+
+func TestExpandFailures(t *testing.T) {
+	var src interface{}
+	json.Unmarshal([]byte(`{"items":[{"a":1},{"a":2}],"b":1}`), &src)
+	patches := []string{
+		`[{"op":"move","from":"/b","path":"/items/*/b"}]`,
+		`[{"op":"add","path":"/items/*","value":1}]`,
+		`[{"op":"add","path":"/items/*/a"}]`,
+		`[{"op":"frob","path":"/b"}]`,
+		`[{"op":"test","path":"/b","value":1},{"op":"test","path":"/items/*/a","value":1}]`,
+	}
+	for _, p := range patches {
+		if _, err := Expand(src, []byte(p)); err == nil {
+			t.Errorf("Expected expanding `%v` to fail", p)
+		}
+	}
+	_, err, loc := ApplyExpanded(src, []byte(patches[4]))
+	if err == nil || loc != 1 {
+		t.Errorf("Expected `%v` to fail at operation 1, not %v (%v)", patches[4], loc, err)
+	}
+}
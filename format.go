@@ -0,0 +1,324 @@
+package jsonpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/VictorLowther/jsonpatch/internal/docutil"
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// span is where a JSON value sits in the document it was parsed from.
+type span struct {
+	start, end int
+	// members holds the members of an object.
+	members []member
+	// elems holds the elements of an array.
+	elems []*span
+	kind  byte
+}
+
+// member is where a member of an object sits in the document.
+type member struct {
+	key              string
+	keyStart, keyEnd int
+	val              *span
+}
+
+// spanParser finds the spans of every value in a JSON document.  It
+// expects the document to already be known to be valid JSON.
+type spanParser struct {
+	buf []byte
+	pos int
+}
+
+func (sp *spanParser) skipSpace() {
+	for sp.pos < len(sp.buf) && strings.IndexByte(" \t\r\n", sp.buf[sp.pos]) != -1 {
+		sp.pos++
+	}
+}
+
+func (sp *spanParser) skipString() {
+	sp.pos++
+	for sp.buf[sp.pos] != '"' {
+		if sp.buf[sp.pos] == '\\' {
+			sp.pos++
+		}
+		sp.pos++
+	}
+	sp.pos++
+}
+
+func (sp *spanParser) value() (*span, error) {
+	sp.skipSpace()
+	res := &span{start: sp.pos, kind: sp.buf[sp.pos]}
+	switch res.kind {
+	case '{':
+		sp.pos++
+		seen := map[string]bool{}
+		for {
+			sp.skipSpace()
+			if sp.buf[sp.pos] == '}' {
+				break
+			}
+			if sp.buf[sp.pos] == ',' {
+				sp.pos++
+				sp.skipSpace()
+			}
+			m := member{keyStart: sp.pos}
+			sp.skipString()
+			m.keyEnd = sp.pos
+			if err := json.Unmarshal(sp.buf[m.keyStart:m.keyEnd], &m.key); err != nil {
+				return nil, err
+			}
+			if seen[m.key] {
+				return nil, fmt.Errorf("Duplicate key %v at offset %d", m.key, m.keyStart)
+			}
+			seen[m.key] = true
+			sp.skipSpace()
+			sp.pos++ // The colon
+			val, err := sp.value()
+			if err != nil {
+				return nil, err
+			}
+			m.val = val
+			res.members = append(res.members, m)
+		}
+		sp.pos++
+	case '[':
+		sp.pos++
+		for {
+			sp.skipSpace()
+			if sp.buf[sp.pos] == ']' {
+				break
+			}
+			if sp.buf[sp.pos] == ',' {
+				sp.pos++
+			}
+			val, err := sp.value()
+			if err != nil {
+				return nil, err
+			}
+			res.elems = append(res.elems, val)
+		}
+		sp.pos++
+	case '"':
+		sp.skipString()
+		res.kind = 0
+	default:
+		for sp.pos < len(sp.buf) && strings.IndexByte(" \t\r\n,]}", sp.buf[sp.pos]) == -1 {
+			sp.pos++
+		}
+		res.kind = 0
+	}
+	res.end = sp.pos
+	return res, nil
+}
+
+// splicer renders a changed document by copying every part of the
+// original that did not change, and marshalling only the parts that did.
+type splicer struct {
+	buf  []byte
+	unit string
+	out  bytes.Buffer
+}
+
+// indentUnit guesses the string the document indents nested values
+// with.  It is empty if the document is all on one line.
+func indentUnit(buf []byte) string {
+	res := ""
+	for _, line := range strings.Split(string(buf), "\n")[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		ws := line[:len(line)-len(trimmed)]
+		if ws != "" && (res == "" || len(ws) < len(res)) {
+			res = ws
+		}
+	}
+	return res
+}
+
+// lineIndent returns the indentation of the line pos is on.
+func (s *splicer) lineIndent(pos int) string {
+	start := bytes.LastIndexByte(s.buf[:pos], '\n') + 1
+	end := start
+	for end < len(s.buf) && (s.buf[end] == ' ' || s.buf[end] == '\t') {
+		end++
+	}
+	return string(s.buf[start:end])
+}
+
+// marshal writes val, indented to fit in at a line indented by prefix.
+func (s *splicer) marshal(val interface{}, prefix string) error {
+	var buf []byte
+	var err error
+	if s.unit == "" {
+		buf, err = json.Marshal(val)
+	} else {
+		buf, err = json.MarshalIndent(val, prefix, s.unit)
+	}
+	s.out.Write(buf)
+	return err
+}
+
+// render writes the new text for the value at sp, which was base and
+// is now target.
+func (s *splicer) render(sp *span, base, target interface{}) error {
+	if equal(base, target) {
+		s.out.Write(s.buf[sp.start:sp.end])
+		return nil
+	}
+	if sp.kind == '{' {
+		baseVal, ok1 := base.(*utils.Object)
+		targetVal, ok2 := target.(*utils.Object)
+		if ok1 && ok2 {
+			return s.renderObject(sp, baseVal, targetVal)
+		}
+	}
+	if sp.kind == '[' {
+		baseVal, ok1 := base.([]interface{})
+		targetVal, ok2 := target.([]interface{})
+		if ok1 && ok2 {
+			return s.renderArray(sp, baseVal, targetVal)
+		}
+	}
+	return s.marshal(target, s.lineIndent(sp.start))
+}
+
+// layout works out the whitespace of a container.  lead is what
+// comes before the first item, trail what comes after the last one,
+// and sep what goes between items when the container changes size.
+func (s *splicer) layout(sp *span, starts, ends []int) (lead, trail, sep, indent string) {
+	if len(starts) == 0 {
+		trail = string(s.buf[sp.start+1 : sp.end-1])
+		if s.unit != "" {
+			indent = s.lineIndent(sp.start) + s.unit
+			lead, trail = "\n"+indent, "\n"+s.lineIndent(sp.start)
+		}
+		return lead, trail, ",", indent
+	}
+	lead = string(s.buf[sp.start+1 : starts[0]])
+	trail = string(s.buf[ends[len(ends)-1] : sp.end-1])
+	sep = "," + lead
+	if len(starts) > 1 {
+		sep = string(s.buf[ends[0]:starts[1]])
+	}
+	return lead, trail, sep, s.lineIndent(starts[0])
+}
+
+func (s *splicer) renderObject(sp *span, base, target *utils.Object) error {
+	starts, ends := make([]int, len(sp.members)), make([]int, len(sp.members))
+	for i, m := range sp.members {
+		starts[i], ends[i] = m.keyStart, m.val.end
+	}
+	lead, trail, sep, indent := s.layout(sp, starts, ends)
+	colon := ":"
+	if len(sp.members) > 0 {
+		colon = string(s.buf[sp.members[0].keyEnd:sp.members[0].val.start])
+	}
+	resized := target.Len() != base.Len()
+	s.out.WriteByte('{')
+	s.out.WriteString(lead)
+	n := 0
+	for i, m := range sp.members {
+		val, ok := target.Get(m.key)
+		if !ok {
+			resized = true
+			continue
+		}
+		if n > 0 {
+			if resized {
+				s.out.WriteString(sep)
+			} else {
+				s.out.Write(s.buf[ends[i-1]:starts[i]])
+			}
+		}
+		n++
+		s.out.Write(s.buf[m.keyStart:m.val.start])
+		old, _ := base.Get(m.key)
+		if err := s.render(m.val, old, val); err != nil {
+			return err
+		}
+	}
+	for _, k := range target.Keys() {
+		if _, ok := base.Get(k); ok {
+			continue
+		}
+		if n > 0 {
+			s.out.WriteString(sep)
+		}
+		n++
+		key, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		s.out.Write(key)
+		s.out.WriteString(colon)
+		val, _ := target.Get(k)
+		if err := s.marshal(val, indent); err != nil {
+			return err
+		}
+	}
+	s.out.WriteString(trail)
+	s.out.WriteByte('}')
+	return nil
+}
+
+func (s *splicer) renderArray(sp *span, base, target []interface{}) error {
+	starts, ends := make([]int, len(sp.elems)), make([]int, len(sp.elems))
+	for i, e := range sp.elems {
+		starts[i], ends[i] = e.start, e.end
+	}
+	lead, trail, sep, indent := s.layout(sp, starts, ends)
+	pairs := docutil.Align(base, target, equal)
+	resized := len(base) != len(target)
+	for i := range pairs {
+		if pairs[i] != i {
+			resized = true
+		}
+	}
+	s.out.WriteByte('[')
+	s.out.WriteString(lead)
+	for i, from := range pairs {
+		if i > 0 {
+			if resized {
+				s.out.WriteString(sep)
+			} else {
+				s.out.Write(s.buf[ends[i-1]:starts[i]])
+			}
+		}
+		if from == -1 {
+			if err := s.marshal(target[i], indent); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.render(sp.elems[from], base[from], target[i]); err != nil {
+			return err
+		}
+	}
+	s.out.WriteString(trail)
+	s.out.WriteByte(']')
+	return nil
+}
+
+// splice renders target, which was patched from the document in buf,
+// by changing only the bytes of buf that hold values that changed.
+func splice(buf []byte, base, target interface{}) ([]byte, error) {
+	sp := &spanParser{buf: buf}
+	root, err := sp.value()
+	if err != nil {
+		return nil, err
+	}
+	s := &splicer{buf: buf, unit: indentUnit(buf)}
+	s.out.Write(buf[:root.start])
+	if err := s.render(root, base, target); err != nil {
+		return nil, err
+	}
+	s.out.Write(buf[root.end:])
+	return s.out.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package jsonpatch
+
+import "testing"
+
+var formatTests = []orderedTest{
+	{
+		`{
+  "name":   "thing",
+  "size": 1.50,
+  "limits": {"cpu": 1e3, "mem": 2},
+  "tags": [ "a", "b" ]
+}
+`,
+		`[{"op":"replace","path":"/name","value":"other"}]`,
+		`{
+  "name":   "other",
+  "size": 1.50,
+  "limits": {"cpu": 1e3, "mem": 2},
+  "tags": [ "a", "b" ]
+}
+`,
+	},
+	{
+		`{
+    "a": 1.0,
+    "b": {
+        "c": [1, 2,   3]
+    }
+}`,
+		`[{"op":"remove","path":"/a"},{"op":"add","path":"/b/d","value":{"x":[1]}},{"op":"remove","path":"/b/c/1"}]`,
+		`{
+    "b": {
+        "c": [1, 3],
+        "d": {
+            "x": [
+                1
+            ]
+        }
+    }
+}`,
+	},
+	{
+		`{"list": [{"id": 1, "v": 1.00}, {"id": 2, "v": 2.00}, {"id": 3, "v": 3.00}]}`,
+		`[{"op":"replace","path":"/list/1/v","value":5},{"op":"add","path":"/list/0","value":{"id":0}}]`,
+		`{"list": [{"id":0}, {"id": 1, "v": 1.00}, {"id": 2, "v": 5}, {"id": 3, "v": 3.00}]}`,
+	},
+	{
+		`  [1.0, {},
+   2]  `,
+		`[{"op":"add","path":"/1/a","value":true},{"op":"move","from":"/2","path":"/0"}]`,
+		`  [2, 1.0, {
+     "a": true
+  }]  `,
+	},
+	{
+		`{"k\"ey": "v\\", "x": [ ]}`,
+		`[{"op":"add","path":"/x/-","value":1},{"op":"replace","path":"/k\"ey","value":"w"}]`,
+		`{"k\"ey": "w", "x": [1 ]}`,
+	},
+	{
+		`"just a string"`,
+		`[{"op":"replace","path":"","value":{"b":1,"a":2}}]`,
+		`{"b":1,"a":2}`,
+	},
+}
+
+func TestApplyPreservingFormat(t *testing.T) {
+	for _, test := range formatTests {
+		res, err, _ := ApplyJSONWithOptions([]byte(test.base), []byte(test.patch), ApplyOptions{PreserveFormat: true})
+		if err != nil {
+			t.Errorf("Failed to apply %v to %v (%v)", test.patch, test.base, err)
+			continue
+		}
+		if string(res) != test.result {
+			t.Errorf("Applying %v: expected\n%v\ngot\n%v", test.patch, test.result, string(res))
+		}
+	}
+	if _, err, _ := ApplyJSONWithOptions([]byte(`{"a":1,"a":2}`), []byte(`[]`), ApplyOptions{PreserveFormat: true}); err == nil {
+		t.Errorf("Expected duplicate keys to be rejected")
+	}
+}
@@ -2,76 +2,594 @@ package jsonpatch
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
 
+	"github.com/VictorLowther/jsonpatch/internal/docutil"
 	"github.com/VictorLowther/jsonpatch/utils"
 )
 
-// This generator does not create copy or move patch ops, and I don't
-// care enough to optimize it to do so.  Ditto for slice handling.
-// There is a lot of optimization that could be done here, but it can get complex real quick.
-func basicGen(base, target interface{}, paranoid bool, ptr pointer) patch {
-	res := make(patch, 0)
-	if reflect.TypeOf(base) != reflect.TypeOf(target) {
-		if paranoid {
-			res = append(res, operation{"test", ptr, nil, utils.Clone(base)})
+// Paranoia controls which test ops a generated patch will contain.
+// More tests make a patch safer to apply to a document that may have
+// changed since the patch was generated, at the cost of patch size.
+type Paranoia int
+
+const (
+	// NoTests generates a patch without any test ops.
+	NoTests Paranoia = iota
+	// TestLeaves tests the original value of everything that is
+	// removed or replaced right before the op that changes it.
+	// This is what Generate does when paranoid is true.
+	TestLeaves
+	// TestParents tests the original value of each object whose
+	// members are removed, replaced, or added, once, before any of
+	// its members are changed.  Unlike TestLeaves, this also
+	// guards additions.
+	TestParents
+	// TestVersion tests a single version or hash field, named by
+	// GenerateOptions.VersionPath, once at the start of the patch.
+	TestVersion
+	// TestDocument tests the entire original document once at the
+	// start of the patch.
+	TestDocument
+)
+
+// GenerateOptions controls how GenerateWithOptions builds a patch.
+type GenerateOptions struct {
+	// Paranoia determines which test ops are added to the patch.
+	Paranoia Paranoia
+	// VersionPath is a JSON pointer to the field that will be
+	// tested when Paranoia is TestVersion.  It must exist in base.
+	VersionPath string
+	// BumpVersion, if set when Paranoia is TestVersion, is called
+	// with the value at VersionPath in base, and the patch will
+	// replace the version with what it returns right after testing
+	// it.  Whatever target has at VersionPath is then ignored.
+	// IncrementVersion is a reasonable choice here.
+	BumpVersion func(interface{}) (interface{}, error)
+	// Ignore is a list of JSON pointers that the generated patch
+	// will not touch, along with anything underneath them.  A
+	// segment that is just `*` matches any member name or array
+	// index, so `/items/*/status` ignores the status of every item.
+	// Arrays with something ignored inside them are diffed element by
+	// element rather than replaced wholesale: elements are paired by
+	// position, and any extra elements are removed from or added to
+	// the end.
+	Ignore []string
+	// MergeKeys maps JSON pointers to arrays of objects onto the name
+	// of the member that identifies each object in the array, such
+	// as `name` or `id`.  Elements of those arrays are diffed by
+	// identity instead of position, so inserting or reordering
+	// elements generates targeted ops instead of replacing the whole
+	// array.  Pointers may contain `*` wildcards as in Ignore.  An
+	// array whose elements cannot all be identified by their key is
+	// replaced wholesale.
+	MergeKeys map[string]string
+	// Schema, if set, is the JSON Schema that base and target
+	// follow, and is used to make the generated patch smarter:
+	//
+	//   - properties marked readOnly are left alone, as with Ignore,
+	//   - arrays with an `x-merge-key` keyword naming the member that
+	//     identifies their elements are diffed as with MergeKeys,
+	//   - arrays with uniqueItems are treated as unordered sets, so
+	//     only elements that were removed or added generate ops,
+	//   - if the ops for an object or array would leave it invalid
+	//     part way through the patch, it is replaced wholesale instead.
+	//
+	// MergeKeys takes precedence over `x-merge-key`.  Only schemas
+	// pulled in with $ref and allOf are looked at for annotations.
+	Schema *Schema
+	// PreserveOrder makes GenerateJSONWithOptions keep the order of
+	// keys in objects, so that objects added by the patch have
+	// their keys in the same order as target.
+	PreserveOrder bool
+	// Equal controls which values the generator considers unchanged.
+	// For example, with IgnoreCase set, changing the case of a string
+	// does not generate an op.
+	Equal EqualOptions
+}
+
+// mergeKey is a parsed entry from GenerateOptions.MergeKeys.
+type mergeKey struct {
+	pat pattern
+	key string
+}
+
+// generator holds the state needed while diffing two documents.
+type generator struct {
+	opts GenerateOptions
+	// version is VersionPath, if the diff must leave it alone.
+	version   Pointer
+	ignore    []pattern
+	cmp       *comparer
+	mergeKeys []mergeKey
+}
+
+// skip returns true if the generator should leave ptr alone.
+func (g *generator) skip(ptr Pointer) bool {
+	if g.version != nil && ptr.Equal(g.version) {
+		return true
+	}
+	for _, pat := range g.ignore {
+		if pat.Matches(ptr) {
+			return true
 		}
-		res = append(res, operation{"replace", ptr, nil, utils.Clone(target)})
+	}
+	return false
+}
+
+// skipsUnder returns true if the generator should leave something
+// underneath ptr alone.
+func (g *generator) skipsUnder(ptr Pointer) bool {
+	under := func(pat pattern) bool {
+		return len(pat) > len(ptr) && pat[:len(ptr)].Matches(ptr)
+	}
+	if g.version != nil && under(pattern(g.version)) {
+		return true
+	}
+	for _, pat := range g.ignore {
+		if under(pat) {
+			return true
+		}
+	}
+	return false
+}
+
+// test returns a test op for val at ptr if leaf tests were asked for.
+func (g *generator) test(ptr Pointer, val interface{}) Patch {
+	if g.opts.Paranoia != TestLeaves {
+		return nil
+	}
+	return Patch{Operation{"test", ptr, nil, utils.Clone(val)}}
+}
+
+// This generator does not create copy ops, and I don't care enough
+// to optimize it to do so.  Arrays are replaced wholesale unless they
+// have a merge key, in which case their elements are matched up by
+// key and the generator will move them around as needed, or the
+// schema says they are sets.
+// There is a lot of optimization that could be done here, but it can get complex real quick.
+//
+// Object members are always visited in sorted key order, and the ops
+// for each object are emitted as removals first, then changes to
+// members present in both base and target, and finally additions.
+// That keeps the generated patch stable for identical inputs.
+func (g *generator) gen(base, target interface{}, ptr Pointer, sch schemaSet) Patch {
+	res := make(Patch, 0)
+	if g.skip(ptr) || g.readOnly(sch) {
 		return res
 	}
-	switch baseVal := base.(type) {
+	plainBase, plainTarget := unordered(base), unordered(target)
+	if reflect.TypeOf(plainBase) != reflect.TypeOf(plainTarget) {
+		return append(res, g.replace(base, target, ptr)...)
+	}
+	switch baseVal := plainBase.(type) {
 	case map[string]interface{}:
-		targetVal := target.(map[string]interface{})
-		handled := make(map[string]struct{})
-		// Handle removed and changed first.
-		for k, oldVal := range baseVal {
+		targetVal := plainTarget.(map[string]interface{})
+		// Handle removed first.
+		for _, k := range sortedKeys(baseVal) {
+			if _, ok := targetVal[k]; ok {
+				continue
+			}
 			newPtr := ptr.Append(k)
+			if g.skip(newPtr) || g.readOnly(g.memberSet(sch, k)) {
+				continue
+			}
+			res = append(res, g.test(newPtr, baseVal[k])...)
+			res = append(res, Operation{"remove", newPtr, nil, nil})
+		}
+		// Then changed
+		for _, k := range sortedKeys(baseVal) {
 			newVal, ok := targetVal[k]
 			if !ok {
-				// Generate a remove op
-				if paranoid {
-					res = append(res, operation{"test", newPtr, nil, utils.Clone(oldVal)})
-				}
-				res = append(res, operation{"remove", newPtr, nil, nil})
-			} else {
-				subPatch := basicGen(oldVal, newVal, paranoid, newPtr)
-				res = append(res, subPatch...)
+				continue
 			}
-			handled[k] = struct{}{}
+			res = append(res, g.gen(baseVal[k], newVal, ptr.Append(k), g.memberSet(sch, k))...)
 		}
-		// Now, handle additions
-		for k, newVal := range targetVal {
-			if _, ok := handled[k]; ok {
+		// Now, handle additions, in the order the target has them
+		// if it has one.
+		added := sortedKeys(targetVal)
+		if obj, ok := target.(*utils.Object); ok {
+			added = obj.Keys()
+		}
+		for _, k := range added {
+			if _, ok := baseVal[k]; ok {
+				continue
+			}
+			newPtr := ptr.Append(k)
+			if g.skip(newPtr) || g.readOnly(g.memberSet(sch, k)) {
 				continue
 			}
-			res = append(res, operation{"add", ptr.Append(k), nil, utils.Clone(newVal)})
+			res = append(res, Operation{"add", newPtr, nil, utils.Clone(targetVal[k])})
+		}
+	case []interface{}:
+		targetVal := target.([]interface{})
+		if key, ok := g.mergeKey(ptr, sch); ok {
+			if keyed, ok := g.genKeyed(baseVal, targetVal, key, ptr, sch); ok {
+				res = keyed
+				break
+			}
+		}
+		if unique, _ := sch.keyword("uniqueItems"); unique == true {
+			res = g.genSet(baseVal, targetVal, ptr)
+			break
+		}
+		if !g.skipsUnder(ptr) {
+			res = append(res, g.replace(base, target, ptr)...)
+			break
+		}
+		// Replacing the array would clobber whatever is ignored in
+		// it, so diff the elements both arrays have by position, and
+		// then trim or extend the end.
+		common := len(baseVal)
+		if len(targetVal) < common {
+			common = len(targetVal)
+		}
+		for i := 0; i < common; i++ {
+			res = append(res, g.gen(baseVal[i], targetVal[i], ptr.Append(strconv.Itoa(i)), g.elementSet(sch, i))...)
+		}
+		for i := len(baseVal) - 1; i >= common; i-- {
+			elemPtr := ptr.Append(strconv.Itoa(i))
+			if g.skip(elemPtr) {
+				continue
+			}
+			res = append(res, g.test(elemPtr, baseVal[i])...)
+			res = append(res, Operation{"remove", elemPtr, nil, nil})
+		}
+		for i := common; i < len(targetVal); i++ {
+			res = append(res, Operation{"add", ptr.Append("-"), nil, utils.Clone(targetVal[i])})
 		}
-	// case []interface{}:
-	// Eventually, add code to handle slices more
-	// efficiently.  For now, through, be dumb.
 	default:
-		if !reflect.DeepEqual(base, target) {
-			if paranoid {
-				res = append(res, operation{"test", ptr, nil, utils.Clone(base)})
+		res = append(res, g.replace(base, target, ptr)...)
+	}
+	if !g.safe(base, res, ptr, sch) {
+		res = g.replace(base, target, ptr)
+	}
+	if g.opts.Paranoia == TestParents && touchesMembers(res, ptr) {
+		res = append(Patch{Operation{"test", ptr, nil, utils.Clone(base)}}, res...)
+	}
+	return res
+}
+
+// replace replaces base with target at ptr if they are not the same.
+func (g *generator) replace(base, target interface{}, ptr Pointer) Patch {
+	if g.cmp.equal(base, target, ptr) {
+		return nil
+	}
+	return append(g.test(ptr, base), Operation{"replace", ptr, nil, utils.Clone(target)})
+}
+
+// mergeKey returns the name of the member that identifies the
+// elements of the array at ptr, if one was configured or the schema
+// for the array has one.
+func (g *generator) mergeKey(ptr Pointer, sch schemaSet) (string, bool) {
+	for _, mk := range g.mergeKeys {
+		if mk.pat.Matches(ptr) {
+			return mk.key, true
+		}
+	}
+	if key, ok := sch.keyword("x-merge-key"); ok {
+		if k, ok := key.(string); ok {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// memberSet returns the schemas for the member named key of an object
+// that sch applies to.
+func (g *generator) memberSet(sch schemaSet, key string) schemaSet {
+	if g.opts.Schema == nil {
+		return nil
+	}
+	return g.opts.Schema.memberSet(sch, key)
+}
+
+// elementSet returns the schemas for the element at index of an array
+// that sch applies to.
+func (g *generator) elementSet(sch schemaSet, index int) schemaSet {
+	if g.opts.Schema == nil {
+		return nil
+	}
+	return g.opts.Schema.elementSet(sch, index)
+}
+
+// readOnly returns true if the schema says the value is read-only.
+func (g *generator) readOnly(sch schemaSet) bool {
+	ro, _ := sch.keyword("readOnly")
+	return ro == true
+}
+
+// safe returns true if applying p, which changes base at ptr, one op
+// at a time leaves the value matching its schema after every op but
+// the last.  Only the last op has to get the value to match, since
+// target should.
+func (g *generator) safe(base interface{}, p Patch, ptr Pointer, sch schemaSet) bool {
+	if g.opts.Schema == nil || len(sch) == 0 {
+		return true
+	}
+	doc := utils.Clone(base)
+	for i := 0; i < len(p)-1; i++ {
+		op := Operation{p[i].Op, p[i].Path[len(ptr):], nil, utils.Clone(p[i].Value)}
+		if p[i].From != nil {
+			op.From = p[i].From[len(ptr):]
+		}
+		var err error
+		if doc, err = op.Apply(doc); err != nil {
+			return false
+		}
+		if op.Op != "test" && !g.opts.Schema.valid(sch, doc, ptr) {
+			return false
+		}
+	}
+	return true
+}
+
+// genSet diffs two arrays whose order does not matter.  Elements
+// that are only in base are removed, from the end of the array
+// towards the start, and then elements only in target are appended.
+func (g *generator) genSet(base, target []interface{}, ptr Pointer) Patch {
+	res := make(Patch, 0)
+	contains := func(list []interface{}, val interface{}) bool {
+		for i := range list {
+			if g.cmp.equal(list[i], val, ptr.Append(strconv.Itoa(i))) {
+				return true
 			}
-			res = append(res, operation{"replace", ptr, nil, utils.Clone(target)})
+		}
+		return false
+	}
+	for i := len(base) - 1; i >= 0; i-- {
+		if contains(target, base[i]) {
+			continue
+		}
+		elemPtr := ptr.Append(strconv.Itoa(i))
+		res = append(res, g.test(elemPtr, base[i])...)
+		res = append(res, Operation{"remove", elemPtr, nil, nil})
+	}
+	for i := range target {
+		if !contains(base, target[i]) {
+			res = append(res, Operation{"add", ptr.Append("-"), nil, utils.Clone(target[i])})
 		}
 	}
 	return res
 }
 
+// identify returns the identity of every element in vals, as
+// determined by the value of its key member.  If any element is not
+// an object with that member, or two elements have the same identity,
+// the list cannot be diffed by key.
+func identify(vals []interface{}, key string) ([]string, map[string]int, bool) {
+	ids := make([]string, len(vals))
+	idx := make(map[string]int, len(vals))
+	for i, v := range vals {
+		obj, ok := unordered(v).(map[string]interface{})
+		if !ok {
+			return nil, nil, false
+		}
+		keyVal, ok := obj[key]
+		if !ok {
+			return nil, nil, false
+		}
+		buf, err := json.Marshal(keyVal)
+		if err != nil {
+			return nil, nil, false
+		}
+		id := string(buf)
+		if _, dup := idx[id]; dup {
+			return nil, nil, false
+		}
+		ids[i] = id
+		idx[id] = i
+	}
+	return ids, idx, true
+}
+
+// genKeyed diffs two arrays whose elements are objects identified by
+// their key member.  Elements are matched up by identity instead of
+// by position, so the ops are, in order:
+//
+//   - removals of elements that are not in target, from the end of
+//     the array towards the start so that indexes stay valid,
+//   - moves that put the remaining elements into target order,
+//   - changes within each remaining element,
+//   - additions of new elements in target order.
+//
+// If the elements cannot be identified, genKeyed returns false and
+// the caller should fall back to comparing the arrays as values.
+func (g *generator) genKeyed(base, target []interface{}, key string, ptr Pointer, sch schemaSet) (Patch, bool) {
+	baseIDs, baseIdx, ok := identify(base, key)
+	if !ok {
+		return nil, false
+	}
+	targetIDs, targetIdx, ok := identify(target, key)
+	if !ok {
+		return nil, false
+	}
+	res := make(Patch, 0)
+	cur := make([]string, 0, len(base))
+	for i := len(base) - 1; i >= 0; i-- {
+		if _, ok := targetIdx[baseIDs[i]]; ok {
+			cur = append([]string{baseIDs[i]}, cur...)
+			continue
+		}
+		elemPtr := ptr.Append(strconv.Itoa(i))
+		res = append(res, g.test(elemPtr, base[i])...)
+		res = append(res, Operation{"remove", elemPtr, nil, nil})
+	}
+	want := make([]string, 0, len(cur))
+	for _, id := range targetIDs {
+		if _, ok := baseIdx[id]; ok {
+			want = append(want, id)
+		}
+	}
+	for i := range want {
+		if cur[i] == want[i] {
+			continue
+		}
+		j := i + 1
+		for cur[j] != want[i] {
+			j++
+		}
+		from := ptr.Append(strconv.Itoa(j))
+		res = append(res, g.test(from, base[baseIdx[want[i]]])...)
+		res = append(res, Operation{"move", ptr.Append(strconv.Itoa(i)), from, nil})
+		cur = append(cur[:j], cur[j+1:]...)
+		cur = append(cur[:i], append([]string{want[i]}, cur[i:]...)...)
+	}
+	for i, id := range want {
+		res = append(res, g.gen(base[baseIdx[id]], target[targetIdx[id]], ptr.Append(strconv.Itoa(i)), g.elementSet(sch, i))...)
+	}
+	for i, id := range targetIDs {
+		if _, ok := baseIdx[id]; ok {
+			continue
+		}
+		res = append(res, Operation{"add", ptr.Append(strconv.Itoa(i)), nil, utils.Clone(target[i])})
+	}
+	return res, true
+}
+
+// generate diffs base against target, and then adds whatever tests
+// need to go at the start of the patch.
+func (g *generator) generate(base, target interface{}) (Patch, error) {
+	ignore, err := newPatterns(g.opts.Ignore)
+	if err != nil {
+		return nil, err
+	}
+	if g.cmp, err = newComparer(g.opts.Equal); err != nil {
+		return nil, err
+	}
+	g.ignore = ignore
+	pats := make([]string, 0, len(g.opts.MergeKeys))
+	for pat := range g.opts.MergeKeys {
+		pats = append(pats, pat)
+	}
+	sort.Strings(pats)
+	for _, pat := range pats {
+		mk, err := newPattern(pat)
+		if err != nil {
+			return nil, err
+		}
+		g.mergeKeys = append(g.mergeKeys, mergeKey{mk, g.opts.MergeKeys[pat]})
+	}
+	if g.opts.Paranoia == TestVersion && g.opts.BumpVersion != nil {
+		ptr, err := NewPointer(g.opts.VersionPath)
+		if err != nil {
+			return nil, err
+		}
+		g.version = ptr
+	}
+	var sch schemaSet
+	if g.opts.Schema != nil {
+		sch = g.opts.Schema.rootSet()
+	}
+	res := g.gen(base, target, make(Pointer, 0), sch)
+	if len(res) == 0 {
+		return res, nil
+	}
+	var prelude Patch
+	switch g.opts.Paranoia {
+	case NoTests, TestLeaves:
+	case TestParents:
+		// A changed object will have already tested itself, but
+		// if the whole document was replaced, nothing has.
+		if len(res[0].Path) == 0 && res[0].Op != "test" {
+			prelude = Patch{Operation{"test", res[0].Path, nil, utils.Clone(base)}}
+		}
+	case TestVersion:
+		ptr, err := NewPointer(g.opts.VersionPath)
+		if err != nil {
+			return nil, err
+		}
+		val, err := ptr.Get(base)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot test version at `%v`: %v", g.opts.VersionPath, err)
+		}
+		prelude = Patch{Operation{"test", ptr, nil, utils.Clone(val)}}
+		if g.opts.BumpVersion != nil {
+			next, err := g.opts.BumpVersion(utils.Clone(val))
+			if err != nil {
+				return nil, err
+			}
+			prelude = append(prelude, Operation{"replace", ptr, nil, next})
+		}
+	case TestDocument:
+		prelude = Patch{Operation{"test", make(Pointer, 0), nil, utils.Clone(base)}}
+	default:
+		return nil, fmt.Errorf("Invalid paranoia level %v", g.opts.Paranoia)
+	}
+	return append(prelude, res...), nil
+}
+
+// touchesMembers returns true if any op in p changes a direct member
+// of the container at ptr.
+func touchesMembers(p Patch, ptr Pointer) bool {
+	for _, op := range p {
+		if op.Op != "test" && len(op.Path) == len(ptr)+1 {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	return docutil.SortedKeys(m)
+}
+
 // Generate generates a JSON Patch that will modify base into target.
 // If paranoid is true, then the generated patch will have test checks.
 //
+// The generated patch is deterministic: for the same base and target,
+// Generate always returns the same bytes.  Object members are visited
+// in sorted key order, and within each object removals come first,
+// followed by replacements, followed by additions.
+//
 // base and target must be the result of unmarshalling JSON into an interface{}
 func Generate(base, target interface{}, paranoid bool) ([]byte, error) {
-	p := basicGen(base, target, paranoid, make(pointer, 0))
+	opts := GenerateOptions{}
+	if paranoid {
+		opts.Paranoia = TestLeaves
+	}
+	return GenerateWithOptions(base, target, opts)
+}
+
+// GenerateWithOptions does the same thing as Generate, except that
+// opts controls how the patch is built.
+func GenerateWithOptions(base, target interface{}, opts GenerateOptions) ([]byte, error) {
+	g := &generator{opts: opts}
+	p, err := g.generate(base, target)
+	if err != nil {
+		return nil, err
+	}
 	return json.Marshal(p)
 }
 
 // GenerateJSON does the same thing as Generate, except base and
 // target should be byte arrays containing raw JSON
 func GenerateJSON(base, target []byte, paranoid bool) ([]byte, error) {
+	opts := GenerateOptions{}
+	if paranoid {
+		opts.Paranoia = TestLeaves
+	}
+	return GenerateJSONWithOptions(base, target, opts)
+}
+
+// GenerateJSONWithOptions does the same thing as GenerateWithOptions,
+// except base and target should be byte arrays containing raw JSON
+func GenerateJSONWithOptions(base, target []byte, opts GenerateOptions) ([]byte, error) {
+	if opts.PreserveOrder {
+		rawBase, err := utils.UnmarshalOrdered(base)
+		if err != nil {
+			return nil, err
+		}
+		rawTarget, err := utils.UnmarshalOrdered(target)
+		if err != nil {
+			return nil, err
+		}
+		return GenerateWithOptions(rawBase, rawTarget, opts)
+	}
 	var rawBase, rawTarget interface{}
 	if err := json.Unmarshal(base, &rawBase); err != nil {
 		return nil, err
@@ -79,5 +597,5 @@ func GenerateJSON(base, target []byte, paranoid bool) ([]byte, error) {
 	if err := json.Unmarshal(target, &rawTarget); err != nil {
 		return nil, err
 	}
-	return Generate(rawBase, rawTarget, paranoid)
+	return GenerateWithOptions(rawBase, rawTarget, opts)
 }
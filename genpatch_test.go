@@ -0,0 +1,312 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type genTest struct {
+	desc   string
+	base   string
+	target string
+	opts   GenerateOptions
+	patch  string
+}
+
+var genTests = []genTest{
+	{
+		`Removes, then replaces, then adds`,
+		`{"d":1,"c":2,"b":3,"a":4}`,
+		`{"a":5,"b":3,"e":6,"f":7}`,
+		GenerateOptions{},
+		`[{"op":"remove","path":"/c"},{"op":"remove","path":"/d"},{"op":"replace","path":"/a","value":5},{"op":"add","path":"/e","value":6},{"op":"add","path":"/f","value":7}]`,
+	},
+	{
+		`Nested objects are visited in sorted order`,
+		`{"z":{"b":1,"a":1},"y":{"b":1,"a":1}}`,
+		`{"z":{"b":2,"a":2},"y":{"b":2,"a":2}}`,
+		GenerateOptions{},
+		`[{"op":"replace","path":"/y/a","value":2},{"op":"replace","path":"/y/b","value":2},{"op":"replace","path":"/z/a","value":2},{"op":"replace","path":"/z/b","value":2}]`,
+	},
+	{
+		`Deep sibling paths do not clobber each other`,
+		`{"a":{"b":{"c":{"x":1,"y":1,"z":1}}}}`,
+		`{"a":{"b":{"c":{"x":2,"y":2,"z":2}}}}`,
+		GenerateOptions{},
+		`[{"op":"replace","path":"/a/b/c/x","value":2},{"op":"replace","path":"/a/b/c/y","value":2},{"op":"replace","path":"/a/b/c/z","value":2}]`,
+	},
+	{
+		`Keys that need escaping`,
+		`{"a/b":1,"c~d":1}`,
+		`{"a/b":2}`,
+		GenerateOptions{},
+		`[{"op":"remove","path":"/c~0d"},{"op":"replace","path":"/a~1b","value":2}]`,
+	},
+	{
+		`Paranoid tests precede their ops`,
+		`{"b":1,"a":1}`,
+		`{"a":2,"c":3}`,
+		GenerateOptions{Paranoia: TestLeaves},
+		`[{"op":"test","path":"/b","value":1},{"op":"remove","path":"/b"},{"op":"test","path":"/a","value":1},{"op":"replace","path":"/a","value":2},{"op":"add","path":"/c","value":3}]`,
+	},
+	{
+		`Parent tests guard additions`,
+		`{"a":{"b":1},"c":1}`,
+		`{"a":{"b":1,"x":2},"c":1}`,
+		GenerateOptions{Paranoia: TestParents},
+		`[{"op":"test","path":"/a","value":{"b":1}},{"op":"add","path":"/a/x","value":2}]`,
+	},
+	{
+		`Parent tests for each directly changed object`,
+		`{"a":{"b":1},"c":1}`,
+		`{"a":{"b":2},"c":2}`,
+		GenerateOptions{Paranoia: TestParents},
+		`[{"op":"test","path":"","value":{"a":{"b":1},"c":1}},{"op":"test","path":"/a","value":{"b":1}},{"op":"replace","path":"/a/b","value":2},{"op":"replace","path":"/c","value":2}]`,
+	},
+	{
+		`Parent tests when the whole document is replaced`,
+		`{"a":1}`,
+		`[1]`,
+		GenerateOptions{Paranoia: TestParents},
+		`[{"op":"test","path":"","value":{"a":1}},{"op":"replace","path":"","value":[1]}]`,
+	},
+	{
+		`Version test`,
+		`{"meta":{"version":3},"a":1,"b":1}`,
+		`{"meta":{"version":3},"a":2,"b":2}`,
+		GenerateOptions{Paranoia: TestVersion, VersionPath: "/meta/version"},
+		`[{"op":"test","path":"/meta/version","value":3},{"op":"replace","path":"/a","value":2},{"op":"replace","path":"/b","value":2}]`,
+	},
+	{
+		`Whole document test`,
+		`{"a":1,"b":1}`,
+		`{"a":2,"b":1}`,
+		GenerateOptions{Paranoia: TestDocument},
+		`[{"op":"test","path":"","value":{"a":1,"b":1}},{"op":"replace","path":"/a","value":2}]`,
+	},
+	{
+		`No changes means no tests`,
+		`{"a":1}`,
+		`{"a":1}`,
+		GenerateOptions{Paranoia: TestDocument},
+		`[]`,
+	},
+	{
+		`Ignored paths are not touched`,
+		`{"spec":{"a":1},"status":{"ready":false},"metadata":{"name":"x","uid":"1"}}`,
+		`{"spec":{"a":2},"metadata":{"name":"x","uid":"2"}}`,
+		GenerateOptions{Ignore: []string{"/status", "/metadata/uid"}},
+		`[{"op":"replace","path":"/spec/a","value":2}]`,
+	},
+	{
+		`Ignored paths with wildcards`,
+		`{"items":{"a":{"id":1,"on":true},"b":{"id":2,"on":true}}}`,
+		`{"items":{"a":{"on":false},"b":{"id":3,"on":true,"x":1}}}`,
+		GenerateOptions{Ignore: []string{"/items/*/id"}},
+		`[{"op":"replace","path":"/items/a/on","value":false},{"op":"add","path":"/items/b/x","value":1}]`,
+	},
+	{
+		`Ignored paths inside arrays`,
+		`{"items":[{"id":1,"on":true},{"id":2,"on":true}]}`,
+		`{"items":[{"id":3,"on":false},{"id":4,"on":true}]}`,
+		GenerateOptions{Ignore: []string{"/items/*/id"}},
+		`[{"op":"replace","path":"/items/0/on","value":false}]`,
+	},
+	{
+		`Ignored paths inside arrays that grow`,
+		`{"items":[{"id":1,"on":true}]}`,
+		`{"items":[{"id":3,"on":false},{"id":4,"on":true}]}`,
+		GenerateOptions{Ignore: []string{"/items/*/id"}},
+		`[{"op":"replace","path":"/items/0/on","value":false},{"op":"add","path":"/items/-","value":{"id":4,"on":true}}]`,
+	},
+	{
+		`Ignored paths inside arrays that shrink`,
+		`{"items":[{"id":1,"on":true},{"id":2,"on":true},{"id":3,"on":true}]}`,
+		`{"items":[{"id":4,"on":false}]}`,
+		GenerateOptions{Ignore: []string{"/items/*/id"}, Paranoia: TestLeaves},
+		`[{"op":"test","path":"/items/0/on","value":true},{"op":"replace","path":"/items/0/on","value":false},{"op":"test","path":"/items/2","value":{"id":3,"on":true}},{"op":"remove","path":"/items/2"},{"op":"test","path":"/items/1","value":{"id":2,"on":true}},{"op":"remove","path":"/items/1"}]`,
+	},
+}
+
+func TestGenerateOrder(t *testing.T) {
+	for _, test := range genTests {
+		t.Log(test.desc)
+		// Run each case a few times, since map iteration order is
+		// randomized and a lucky run could hide nondeterminism.
+		for i := 0; i < 10; i++ {
+			res, err := GenerateJSONWithOptions([]byte(test.base), []byte(test.target), test.opts)
+			if err != nil {
+				t.Errorf("Failed to generate patch from `%v` to `%v` (%v)", test.base, test.target, err)
+				break
+			}
+			if string(res) != test.patch {
+				t.Errorf("Generated patch \n\t`%v` \nis not equal to expected patch \n\t`%v`", string(res), test.patch)
+				break
+			}
+		}
+	}
+}
+
+func TestGenerateBadVersion(t *testing.T) {
+	opts := GenerateOptions{Paranoia: TestVersion, VersionPath: "/version"}
+	if _, err := GenerateJSONWithOptions([]byte(`{"a":1}`), []byte(`{"a":2}`), opts); err == nil {
+		t.Errorf("Expected generating a version test for a missing version to fail")
+	}
+}
+
+func mustSchema(s string) *Schema {
+	res, err := NewSchemaJSON([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+var genSchema = mustSchema(`{
+	"$defs":{"status":{"readOnly":true}},
+	"properties":{
+		"id":{"readOnly":true},
+		"status":{"$ref":"#/$defs/status"},
+		"containers":{"type":"array","x-merge-key":"name"},
+		"tags":{"type":"array","uniqueItems":true},
+		"one":{"type":"object","minProperties":1},
+		"nested":{"type":"object","required":["a"]}
+	}
+}`)
+
+var schemaGenTests = []genTest{
+	{
+		`Read-only properties are left alone`,
+		`{"id":1,"status":{"ready":false},"x":1}`,
+		`{"id":2,"status":{"ready":true},"x":2}`,
+		GenerateOptions{Schema: genSchema},
+		`[{"op":"replace","path":"/x","value":2}]`,
+	},
+	{
+		`Read-only properties are not removed or added`,
+		`{"id":1}`,
+		`{"status":{}}`,
+		GenerateOptions{Schema: genSchema},
+		`[]`,
+	},
+	{
+		`Merge keys from the schema`,
+		`{"containers":[{"name":"a","image":"x"},{"name":"b"}]}`,
+		`{"containers":[{"name":"b"},{"name":"a","image":"y"}]}`,
+		GenerateOptions{Schema: genSchema},
+		`[{"from":"/containers/1","op":"move","path":"/containers/0"},{"op":"replace","path":"/containers/1/image","value":"y"}]`,
+	},
+	{
+		`Unique arrays are sets`,
+		`{"tags":["a","b","c"]}`,
+		`{"tags":["c","d","a"]}`,
+		GenerateOptions{Schema: genSchema},
+		`[{"op":"remove","path":"/tags/1"},{"op":"add","path":"/tags/-","value":"d"}]`,
+	},
+	{
+		`Objects that would become invalid are replaced`,
+		`{"one":{"a":1},"nested":{"a":1,"b":1}}`,
+		`{"one":{"b":1},"nested":{"a":2}}`,
+		GenerateOptions{Schema: genSchema},
+		`[{"op":"remove","path":"/nested/b"},{"op":"replace","path":"/nested/a","value":2},{"op":"replace","path":"/one","value":{"b":1}}]`,
+	},
+	{
+		`Without a schema, the same objects get member ops`,
+		`{"one":{"a":1}}`,
+		`{"one":{"b":1}}`,
+		GenerateOptions{},
+		`[{"op":"remove","path":"/one/a"},{"op":"add","path":"/one/b","value":1}]`,
+	},
+}
+
+func TestGenerateWithSchema(t *testing.T) {
+	for _, test := range schemaGenTests {
+		t.Log(test.desc)
+		res, err := GenerateJSONWithOptions([]byte(test.base), []byte(test.target), test.opts)
+		if err != nil {
+			t.Errorf("Failed to generate patch from `%v` to `%v` (%v)", test.base, test.target, err)
+			continue
+		}
+		if string(res) != test.patch {
+			t.Errorf("Generated patch \n\t`%v` \nis not equal to expected patch \n\t`%v`", string(res), test.patch)
+		}
+	}
+}
+
+var keyedTests = []genTest{
+	{
+		`Keyed elements are matched by identity`,
+		`{"l":[{"name":"a","v":1},{"name":"b","v":1}]}`,
+		`{"l":[{"name":"x","v":0},{"name":"a","v":1},{"name":"b","v":2}]}`,
+		GenerateOptions{MergeKeys: map[string]string{"/l": "name"}},
+		`[{"op":"replace","path":"/l/1/v","value":2},{"op":"add","path":"/l/0","value":{"name":"x","v":0}}]`,
+	},
+	{
+		`Keyed elements are removed from the end first`,
+		`{"l":[{"id":1},{"id":2},{"id":3},{"id":4}]}`,
+		`{"l":[{"id":2},{"id":4}]}`,
+		GenerateOptions{MergeKeys: map[string]string{"/l": "id"}},
+		`[{"op":"remove","path":"/l/2"},{"op":"remove","path":"/l/0"}]`,
+	},
+	{
+		`Keyed elements are moved into place`,
+		`{"l":[{"id":1},{"id":2},{"id":3,"x":1}]}`,
+		`{"l":[{"id":3,"x":2},{"id":1},{"id":2}]}`,
+		GenerateOptions{MergeKeys: map[string]string{"/l": "id"}},
+		`[{"from":"/l/2","op":"move","path":"/l/0"},{"op":"replace","path":"/l/0/x","value":2}]`,
+	},
+	{
+		`Everything at once, with wildcard merge keys`,
+		`{"s":[{"n":"a","p":[{"port":80},{"port":443}]},{"n":"b","p":[]},{"n":"c","p":[]}]}`,
+		`{"s":[{"n":"c","p":[]},{"n":"d","p":[]},{"n":"a","p":[{"port":443},{"port":8080}]}]}`,
+		GenerateOptions{MergeKeys: map[string]string{"/s": "n", "/s/*/p": "port"}},
+		`[{"op":"remove","path":"/s/1"},{"from":"/s/1","op":"move","path":"/s/0"},{"op":"remove","path":"/s/1/p/0"},{"op":"add","path":"/s/1/p/1","value":{"port":8080}},{"op":"add","path":"/s/1","value":{"n":"d","p":[]}}]`,
+	},
+	{
+		`Leaf tests for keyed elements`,
+		`{"l":[{"id":1},{"id":2},{"id":3}]}`,
+		`{"l":[{"id":3},{"id":1}]}`,
+		GenerateOptions{Paranoia: TestLeaves, MergeKeys: map[string]string{"/l": "id"}},
+		`[{"op":"test","path":"/l/1","value":{"id":2}},{"op":"remove","path":"/l/1"},{"op":"test","path":"/l/1","value":{"id":3}},{"from":"/l/1","op":"move","path":"/l/0"}]`,
+	},
+	{
+		`Parent tests for keyed elements`,
+		`{"l":[{"id":1,"v":1},{"id":2}]}`,
+		`{"l":[{"id":1,"v":2},{"id":2}]}`,
+		GenerateOptions{Paranoia: TestParents, MergeKeys: map[string]string{"/l": "id"}},
+		`[{"op":"test","path":"/l/0","value":{"id":1,"v":1}},{"op":"replace","path":"/l/0/v","value":2}]`,
+	},
+	{
+		`Unidentifiable elements replace the array`,
+		`{"l":[{"id":1},{"id":1}]}`,
+		`{"l":[{"id":1}]}`,
+		GenerateOptions{MergeKeys: map[string]string{"/l": "id"}},
+		`[{"op":"replace","path":"/l","value":[{"id":1}]}]`,
+	},
+}
+
+func TestGenerateKeyed(t *testing.T) {
+	for _, test := range keyedTests {
+		t.Log(test.desc)
+		res, err := GenerateJSONWithOptions([]byte(test.base), []byte(test.target), test.opts)
+		if err != nil {
+			t.Errorf("Failed to generate patch from `%v` to `%v` (%v)", test.base, test.target, err)
+			continue
+		}
+		if string(res) != test.patch {
+			t.Errorf("Generated patch \n\t`%v` \nis not equal to expected patch \n\t`%v`", string(res), test.patch)
+		}
+		var base, target interface{}
+		json.Unmarshal([]byte(test.base), &base)
+		json.Unmarshal([]byte(test.target), &target)
+		final, err, idx := Apply(base, res)
+		if err != nil {
+			t.Errorf("Failed to apply generated patch `%v`. Failed at operation %v (%v)", string(res), idx, err)
+			continue
+		}
+		if !reflect.DeepEqual(final, target) {
+			t.Errorf("Applying generated patch `%v` to `%v` did not yield `%v`", string(res), test.base, test.target)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// AcceptPatch is the value of the Accept-Patch header that
+// PatchHandler advertises, listing the patch formats it understands.
+const AcceptPatch = ContentType + ", " + MergeContentType
+
+// HTTPError can be returned by the callbacks of a PatchHandler to pick
+// the status code of the response.  Any other error from Load or Save
+// results in a 500.  The hooks in PatchHandler.Options can return one
+// too, so a BeforeOp that enforces a Policy can refuse an operation
+// with a 403.
+type HTTPError struct {
+	Status  int
+	Message string
+}
+
+func (e *HTTPError) Error() string {
+	return e.Message
+}
+
+// PatchHandler is an http.Handler for PATCH endpoints.  It accepts JSON
+// Patches and JSON Merge Patches, applies them to the document Load
+// returns, and hands the result to Save.  It responds with:
+//
+//   - 200 and the patched document if all went well,
+//   - 400 if the patch is malformed,
+//   - 404 or whatever else Load, Save, or the hooks in Options ask
+//     for with an *HTTPError,
+//   - 405 if the request is not a PATCH,
+//   - 409 if a test operation in the patch failed,
+//   - 412 if the request has an If-Match header that does not match
+//     the ETag of the document,
+//   - 415 if the patch is not in a format PatchHandler understands,
+//   - 422 if the patch could not be applied, or the patched document
+//     does not match Options.Schema.
+//
+// Every response includes an Accept-Patch header.
+type PatchHandler struct {
+	// Load returns the document the request refers to, and its ETag
+	// if it has one.  The ETag must include its quotes.
+	Load func(r *http.Request) (doc interface{}, etag string, err error)
+	// Save stores the patched document.  etag is the ETag that Load
+	// returned.  Save returns the ETag of the stored document, if it
+	// has one.
+	Save func(r *http.Request, doc interface{}, etag string) (newETag string, err error)
+	// Options is used when applying patches.  Merge patches are
+	// translated into JSON Patches first, so the hooks and schema
+	// apply to them too.
+	Options ApplyOptions
+	// MaxBodySize limits how large a patch can be.  If it is 0,
+	// patches can be up to 1 MiB.  Larger ones are rejected with 413
+	// Request Entity Too Large.
+	MaxBodySize int64
+}
+
+// httpFail sends an error response.
+func httpFail(w http.ResponseWriter, status int, msg string) {
+	http.Error(w, msg, status)
+}
+
+// httpStatus turns an error from a callback into a response.
+func httpStatus(w http.ResponseWriter, err error) {
+	if e, ok := err.(*HTTPError); ok {
+		httpFail(w, e.Status, e.Message)
+		return
+	}
+	httpFail(w, http.StatusInternalServerError, err.Error())
+}
+
+// etagMatches returns true if the If-Match header allows etag.  As per
+// RFC 7232, If-Match uses strong comparison, so weak ETags never match.
+func etagMatches(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" && etag != "" {
+			return true
+		}
+		if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *PatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", AcceptPatch)
+	switch r.Method {
+	case http.MethodPatch:
+	case http.MethodOptions:
+		w.Header().Set("Allow", "OPTIONS, PATCH")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", "OPTIONS, PATCH")
+		httpFail(w, http.StatusMethodNotAllowed, "Only PATCH is allowed")
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != ContentType && mediaType != MergeContentType) {
+		httpFail(w, http.StatusUnsupportedMediaType, "Patches must be one of "+AcceptPatch)
+		return
+	}
+	limit := h.MaxBodySize
+	if limit == 0 {
+		limit = 1 << 20
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			httpFail(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		httpFail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	doc, etag, err := h.Load(r)
+	if err != nil {
+		httpStatus(w, err)
+		return
+	}
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && !etagMatches(ifMatch, etag) {
+		httpFail(w, http.StatusPreconditionFailed, "The resource has been changed")
+		return
+	}
+	if mediaType == MergeContentType {
+		var merge interface{}
+		if err := json.Unmarshal(body, &merge); err != nil {
+			httpFail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body, err = MergeToJSONPatch(doc, merge); err != nil {
+			httpFail(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	p, err := NewPatch(body)
+	if err != nil {
+		httpFail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	res, err, loc := p.Apply(doc, h.Options)
+	if err != nil {
+		var chosen *HTTPError
+		if errors.As(err, &chosen) {
+			httpFail(w, chosen.Status, chosen.Message)
+			return
+		}
+		status := http.StatusUnprocessableEntity
+		if _, invalid := err.(*ValidationError); !invalid && loc < len(p) && p[loc].Op == "test" {
+			status = http.StatusConflict
+		}
+		httpFail(w, status, err.Error())
+		return
+	}
+	newETag, err := h.Save(r, res, etag)
+	if err != nil {
+		httpStatus(w, err)
+		return
+	}
+	buf, err := json.Marshal(res)
+	if err != nil {
+		httpFail(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if newETag != "" {
+		w.Header().Set("ETag", newETag)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf)
+}
@@ -0,0 +1,138 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type httpTest struct {
+	method, contentType, ifMatch, body string
+	status                             int
+	result                             string
+}
+
+var httpTests = []httpTest{
+	{"PATCH", ContentType, "", `[{"op":"replace","path":"/a","value":2}]`, 200, `{"a":2,"b":{"c":"d"}}`},
+	{"PATCH", ContentType + "; charset=utf-8", `"v1"`, `[{"op":"add","path":"/b/e","value":"f"}]`, 200, `{"a":1,"b":{"c":"d","e":"f"}}`},
+	{"PATCH", MergeContentType, "", `{"a":null,"b":{"e":"f"}}`, 200, `{"b":{"c":"d","e":"f"}}`},
+	{"PATCH", ContentType, `"v0", "v1"`, `[{"op":"remove","path":"/a"}]`, 200, `{"b":{"c":"d"}}`},
+	{"PATCH", ContentType, "*", `[{"op":"remove","path":"/a"}]`, 200, `{"b":{"c":"d"}}`},
+	{"PATCH", ContentType, `"v0"`, `[{"op":"remove","path":"/a"}]`, 412, ""},
+	{"PATCH", ContentType, `W/"v1"`, `[{"op":"remove","path":"/a"}]`, 412, ""},
+	{"PATCH", ContentType, "", `[{"op":"test","path":"/a","value":2}]`, 409, ""},
+	{"PATCH", ContentType, "", `[{"op":"remove","path":"/z"}]`, 422, ""},
+	{"PATCH", ContentType, "", `[{"op":"replace","path":"/a","value":"x"}]`, 422, ""},
+	{"PATCH", ContentType, "", `[{"op":"frob","path":"/a"}]`, 400, ""},
+	{"PATCH", ContentType, "", `{`, 400, ""},
+	{"PATCH", MergeContentType, "", `{`, 400, ""},
+	{"PATCH", "application/json", "", `{}`, 415, ""},
+	{"PUT", ContentType, "", `[]`, 405, ""},
+	{"PATCH", ContentType, "", `[{"op":"add","path":"/z","value":"` + strings.Repeat("z", 64) + `"}]`, 413, ""},
+}
+
+func TestPatchHandler(t *testing.T) {
+	for _, test := range httpTests {
+		var saved interface{}
+		h := &PatchHandler{
+			Load: func(r *http.Request) (interface{}, string, error) {
+				var doc interface{}
+				json.Unmarshal([]byte(`{"a":1,"b":{"c":"d"}}`), &doc)
+				return doc, `"v1"`, nil
+			},
+			Save: func(r *http.Request, doc interface{}, etag string) (string, error) {
+				saved = doc
+				return `"v2"`, nil
+			},
+			Options:     ApplyOptions{Schema: mustSchema(`{"properties":{"a":{"type":"number"}}}`)},
+			MaxBodySize: 64,
+		}
+		req := httptest.NewRequest(test.method, "/thing", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", test.contentType)
+		if test.ifMatch != "" {
+			req.Header.Set("If-Match", test.ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%v `%v`: expected status %v, got %v (%v)", test.method, test.body, test.status, rec.Code, rec.Body.String())
+			continue
+		}
+		if rec.Header().Get("Accept-Patch") != AcceptPatch {
+			t.Errorf("%v `%v`: missing Accept-Patch header", test.method, test.body)
+		}
+		if test.status != 200 {
+			if saved != nil {
+				t.Errorf("%v `%v`: saved a document on failure", test.method, test.body)
+			}
+			continue
+		}
+		var expected, got interface{}
+		json.Unmarshal([]byte(test.result), &expected)
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Errorf("%v `%v`: bad response body (%v)", test.method, test.body, err)
+			continue
+		}
+		if !reflect.DeepEqual(expected, got) || !reflect.DeepEqual(expected, saved) {
+			t.Errorf("%v `%v`: expected %v, got %v", test.method, test.body, test.result, rec.Body.String())
+		}
+		if rec.Header().Get("ETag") != `"v2"` {
+			t.Errorf("%v `%v`: expected ETag \"v2\", got %v", test.method, test.body, rec.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestPatchHandlerErrors(t *testing.T) {
+	h := &PatchHandler{
+		Load: func(r *http.Request) (interface{}, string, error) {
+			return nil, "", &HTTPError{Status: http.StatusNotFound, Message: "No such thing"}
+		},
+	}
+	req := httptest.NewRequest("PATCH", "/thing", strings.NewReader(`[]`))
+	req.Header.Set("Content-Type", ContentType)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %v", rec.Code)
+	}
+}
+
+func TestPatchHandlerHooks(t *testing.T) {
+	load := func(r *http.Request) (interface{}, string, error) {
+		var doc interface{}
+		json.Unmarshal([]byte(`{"spec":{"a":1},"status":{"b":2}}`), &doc)
+		return doc, "", nil
+	}
+	save := func(r *http.Request, doc interface{}, etag string) (string, error) {
+		return "", nil
+	}
+	policy := Policy{Rules: []Rule{{Prefix: "/spec"}}}
+	tests := []struct {
+		opts   ApplyOptions
+		body   string
+		status int
+	}{
+		{ApplyOptions{BeforeOp: func(op *Operation, doc interface{}) error {
+			if err := policy.BeforeOp(op, doc); err != nil {
+				return &HTTPError{Status: http.StatusForbidden, Message: err.Error()}
+			}
+			return nil
+		}}, `[{"op":"replace","path":"/status/b","value":3}]`, 403},
+		{ApplyOptions{BeforeOp: policy.BeforeOp}, `[{"op":"replace","path":"/status/b","value":3}]`, 422},
+		{ApplyOptions{BeforeOp: policy.BeforeOp}, `[{"op":"replace","path":"/spec/a","value":3}]`, 200},
+		{ApplyOptions{Equal: EqualOptions{UnorderedArrays: []string{"bad"}}}, `[]`, 422},
+	}
+	for _, test := range tests {
+		h := &PatchHandler{Load: load, Save: save, Options: test.opts}
+		req := httptest.NewRequest("PATCH", "/thing", strings.NewReader(test.body))
+		req.Header.Set("Content-Type", ContentType)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("`%v`: expected status %v, got %v (%v)", test.body, test.status, rec.Code, rec.Body.String())
+		}
+	}
+}
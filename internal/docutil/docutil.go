@@ -0,0 +1,110 @@
+// Package docutil holds helpers for working with decoded JSON documents
+// that jsonpatch shares with its subpackages.
+package docutil
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Align pairs up the elements of two versions of an array, so that
+// elements that were only changed, and not added or removed, can keep
+// their formatting and comments.  Elements that equal says are the same
+// are matched by a longest common subsequence, the unmatched elements
+// between them are paired by position, and any left over are matched
+// with equal elements anywhere in base.  pairs[i] is the index in base
+// of target[i], or -1.
+func Align(base, target []interface{}, equal func(a, b interface{}) bool) []int {
+	lcs := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(target)+1)
+	}
+	for i := len(base) - 1; i >= 0; i-- {
+		for j := len(target) - 1; j >= 0; j-- {
+			if equal(base[i], target[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	pairs := make([]int, len(target))
+	i, j := 0, 0
+	gapBase, gapTarget := 0, 0
+	pairGap := func(endBase, endTarget int) {
+		for gapBase < endBase && gapTarget < endTarget {
+			pairs[gapTarget] = gapBase
+			gapBase++
+			gapTarget++
+		}
+		for ; gapTarget < endTarget; gapTarget++ {
+			pairs[gapTarget] = -1
+		}
+	}
+	for i < len(base) && j < len(target) {
+		switch {
+		case equal(base[i], target[j]):
+			pairGap(i, j)
+			pairs[j] = i
+			i++
+			j++
+			gapBase, gapTarget = i, j
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	pairGap(len(base), len(target))
+	// Elements that were moved rather than changed end up unpaired,
+	// but they can still be copied as they were.
+	used := make([]bool, len(base))
+	for _, from := range pairs {
+		if from != -1 {
+			used[from] = true
+		}
+	}
+	for j := range pairs {
+		if pairs[j] != -1 {
+			continue
+		}
+		for i := range base {
+			if !used[i] && equal(base[i], target[j]) {
+				pairs[j] = i
+				used[i] = true
+				break
+			}
+		}
+	}
+	return pairs
+}
+
+// NormalizeOffset turns the pointer segment selector into an index
+// into an array with bound elements.  Negative selectors count back
+// from the end.
+func NormalizeOffset(selector string, bound int) (int, error) {
+	res, err := strconv.Atoi(selector)
+	if err != nil {
+		return -1, err
+	}
+	if res < 0 {
+		res = bound + res
+	}
+	if res >= bound || res < 0 {
+		return -1, fmt.Errorf("Index out of bounds")
+	}
+	return res, nil
+}
+
+// SortedKeys returns the keys of m in sorted order.
+func SortedKeys[V any](m map[string]V) []string {
+	res := make([]string, 0, len(m))
+	for k := range m {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
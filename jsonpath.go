@@ -0,0 +1,378 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// match is a location in a document selected by a path expression.
+type match struct {
+	ptr Pointer
+	val interface{}
+}
+
+// selector is a single step in a path expression.  Given a value and
+// where it is, it returns the locations it selects relative to it.
+type selector interface {
+	selects(ptr Pointer, val interface{}) []match
+}
+
+// children returns all the direct children of val.  Members of a
+// *utils.Object are returned in its key order, members of any other
+// object in sorted key order, and array elements in index order.
+func children(ptr Pointer, val interface{}) []match {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		res := make([]match, 0, len(t))
+		for _, k := range sortedKeys(t) {
+			res = append(res, match{ptr.Append(k), t[k]})
+		}
+		return res
+	case *utils.Object:
+		res := make([]match, 0, t.Len())
+		for _, k := range t.Keys() {
+			v, _ := t.Get(k)
+			res = append(res, match{ptr.Append(k), v})
+		}
+		return res
+	case []interface{}:
+		res := make([]match, 0, len(t))
+		for i := range t {
+			res = append(res, match{ptr.Append(strconv.Itoa(i)), t[i]})
+		}
+		return res
+	default:
+		return nil
+	}
+}
+
+// childSel selects the named members or indexed elements of a value.
+type childSel []string
+
+func (s childSel) selects(ptr Pointer, val interface{}) []match {
+	res := make([]match, 0, len(s))
+	for _, name := range s {
+		switch t := val.(type) {
+		case map[string]interface{}:
+			if v, ok := t[name]; ok {
+				res = append(res, match{ptr.Append(name), v})
+			}
+		case *utils.Object:
+			if v, ok := t.Get(name); ok {
+				res = append(res, match{ptr.Append(name), v})
+			}
+		case []interface{}:
+			if i, err := normalizeOffset(name, len(t)); err == nil {
+				res = append(res, match{ptr.Append(strconv.Itoa(i)), t[i]})
+			}
+		}
+	}
+	return res
+}
+
+// wildSel selects every child of a value.
+type wildSel struct{}
+
+func (wildSel) selects(ptr Pointer, val interface{}) []match {
+	return children(ptr, val)
+}
+
+// descSel applies its selector to a value and all of its descendants.
+type descSel struct {
+	sel selector
+}
+
+func (s descSel) selects(ptr Pointer, val interface{}) []match {
+	res := s.sel.selects(ptr, val)
+	for _, child := range children(ptr, val) {
+		res = append(res, s.selects(child.ptr, child.val)...)
+	}
+	return res
+}
+
+// filterSel selects the children of a value for which a comparison
+// against one of their members holds, or which have that member at
+// all if there is no comparison.
+type filterSel struct {
+	member []string
+	op     string
+	value  interface{}
+}
+
+func (s filterSel) selects(ptr Pointer, val interface{}) []match {
+	res := make([]match, 0)
+	for _, child := range children(ptr, val) {
+		var v interface{} = child.val
+		found := true
+		for _, name := range s.member {
+			m := childSel{name}.selects(nil, v)
+			if len(m) == 0 {
+				found = false
+				break
+			}
+			v = m[0].val
+		}
+		if found && s.holds(v) {
+			res = append(res, child)
+		}
+	}
+	return res
+}
+
+// holds returns true if v passes the filter's comparison.
+func (s filterSel) holds(v interface{}) bool {
+	switch s.op {
+	case "":
+		return true
+	case "==":
+		return equal(v, s.value)
+	case "!=":
+		return !equal(v, s.value)
+	}
+	var cmp int
+	switch a := v.(type) {
+	case float64:
+		b, ok := s.value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	case string:
+		b, ok := s.value.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch s.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// selectorPath is a compiled path expression.
+type selectorPath []selector
+
+// newSelectorPath compiles a path expression.  Expressions that start
+// with `$` are JSONPath; anything else is a JSON pointer in which `*`
+// segments are wildcards.
+func newSelectorPath(s string) (selectorPath, error) {
+	if strings.HasPrefix(s, "$") {
+		return parseJSONPath(s)
+	}
+	pat, err := newPattern(s)
+	if err != nil {
+		return nil, err
+	}
+	res := make(selectorPath, len(pat))
+	for i, seg := range pat {
+		if seg == "*" {
+			res[i] = wildSel{}
+		} else {
+			res[i] = childSel{string(seg)}
+		}
+	}
+	return res, nil
+}
+
+// find returns every location in doc the path selects, without
+// duplicates.  The locations each step selects are in the order of the
+// locations they were selected from, and within each of those in the
+// order children returns them.
+func (p selectorPath) find(doc interface{}) []match {
+	res := []match{{make(Pointer, 0), doc}}
+	for _, sel := range p {
+		next := make([]match, 0)
+		seen := make(map[string]struct{})
+		for _, m := range res {
+			for _, found := range sel.selects(m.ptr, m.val) {
+				key := found.ptr.String()
+				if _, ok := seen[key]; ok {
+					continue
+				}
+				seen[key] = struct{}{}
+				next = append(next, found)
+			}
+		}
+		res = next
+	}
+	return res
+}
+
+// parseJSONPath compiles the supported subset of JSONPath:
+//
+//	$                 the document
+//	.name ['name']    a member of an object
+//	[0] [-1]          an element of an array
+//	['a','b'] [0,1]   several members or elements
+//	.* [*]            every member or element
+//	..name ..*        the above, applied to everything below
+//	[?(@.a.b)]        children with a member at a.b
+//	[?(@.a op val)]   children whose a member compares to a JSON value,
+//	                  where op is one of == != < <= > >=
+func parseJSONPath(s string) (selectorPath, error) {
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("JSONPath `%v` must start with `$`", s)
+	}
+	res := make(selectorPath, 0)
+	rest := s[1:]
+	for rest != "" {
+		desc := false
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			desc = true
+			rest = rest[2:]
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "["):
+		default:
+			return nil, fmt.Errorf("Unexpected `%v` in JSONPath `%v`", rest, s)
+		}
+		var sel selector
+		var err error
+		if strings.HasPrefix(rest, "[") {
+			sel, rest, err = parseBracket(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%v in JSONPath `%v`", err, s)
+			}
+		} else {
+			end := strings.IndexAny(rest, ".[")
+			if end == -1 {
+				end = len(rest)
+			}
+			name := rest[:end]
+			rest = rest[end:]
+			switch name {
+			case "":
+				return nil, fmt.Errorf("Empty member name in JSONPath `%v`", s)
+			case "*":
+				sel = wildSel{}
+			default:
+				sel = childSel{name}
+			}
+		}
+		if desc {
+			sel = descSel{sel}
+		}
+		res = append(res, sel)
+	}
+	return res, nil
+}
+
+// parseBracket parses a bracketed JSONPath step, returning it and
+// whatever follows it.
+func parseBracket(s string) (selector, string, error) {
+	if strings.HasPrefix(s, "[?(") {
+		end := strings.Index(s, ")]")
+		if end == -1 {
+			return nil, "", fmt.Errorf("Unterminated filter")
+		}
+		sel, err := parseFilter(s[3:end])
+		return sel, s[end+2:], err
+	}
+	names := make(childSel, 0)
+	i := 1
+	for {
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("Unterminated `[`")
+		}
+		switch {
+		case s[i] == '*':
+			i++
+			if i >= len(s) || s[i] != ']' || len(names) != 0 {
+				return nil, "", fmt.Errorf("`*` must be alone in brackets")
+			}
+			return wildSel{}, s[i+1:], nil
+		case s[i] == '\'' || s[i] == '"':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end == -1 {
+				return nil, "", fmt.Errorf("Unterminated string")
+			}
+			names = append(names, s[i+1:i+1+end])
+			i += end + 2
+		default:
+			end := strings.IndexAny(s[i:], ",]")
+			if end == -1 {
+				return nil, "", fmt.Errorf("Unterminated `[`")
+			}
+			idx := strings.TrimSpace(s[i : i+end])
+			if _, err := strconv.Atoi(idx); err != nil {
+				return nil, "", fmt.Errorf("`%v` is not an array index", idx)
+			}
+			names = append(names, idx)
+			i += end
+		}
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		if i >= len(s) {
+			return nil, "", fmt.Errorf("Unterminated `[`")
+		}
+		switch s[i] {
+		case ']':
+			return names, s[i+1:], nil
+		case ',':
+			i++
+		default:
+			return nil, "", fmt.Errorf("Unexpected `%c`", s[i])
+		}
+	}
+}
+
+// parseFilter parses the inside of a `[?(...)]` filter.
+func parseFilter(s string) (selector, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "@.") {
+		return nil, fmt.Errorf("Filter `%v` must start with `@.`", s)
+	}
+	s = s[2:]
+	res := filterSel{}
+	end := strings.IndexAny(s, " =!<>")
+	if end == -1 {
+		end = len(s)
+	}
+	res.member = strings.Split(s[:end], ".")
+	s = strings.TrimSpace(s[end:])
+	if s == "" {
+		return res, nil
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if strings.HasPrefix(s, op) {
+			res.op = op
+			break
+		}
+	}
+	if res.op == "" {
+		return nil, fmt.Errorf("Unknown comparison in filter `%v`", s)
+	}
+	lit := strings.TrimSpace(s[len(res.op):])
+	if strings.HasPrefix(lit, "'") && strings.HasSuffix(lit, "'") && len(lit) > 1 {
+		res.value = lit[1 : len(lit)-1]
+		return res, nil
+	}
+	if err := json.Unmarshal([]byte(lit), &res.value); err != nil {
+		return nil, fmt.Errorf("`%v` is not a valid value in a filter", lit)
+	}
+	return res, nil
+}
@@ -0,0 +1,113 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+const pathDoc = `{
+  "store": {
+    "book": [
+      {"category":"reference","author":"Nigel Rees","price":8.95},
+      {"category":"fiction","author":"Evelyn Waugh","price":12.99},
+      {"category":"fiction","author":"Herman Melville","price":8.99,"isbn":"0-553-21311-3"}
+    ],
+    "bicycle": {"color":"red","price":19.95},
+    "a.b": 1
+  }
+}`
+
+type pathTest struct {
+	path  string
+	found []string
+	valid bool
+}
+
+var pathTests = []pathTest{
+	{`$`, []string{``}, true},
+	{`$.store.bicycle.color`, []string{`/store/bicycle/color`}, true},
+	{`$['store']["a.b"]`, []string{`/store/a.b`}, true},
+	{`$.store.book[0].author`, []string{`/store/book/0/author`}, true},
+	{`$.store.book[-1].author`, []string{`/store/book/2/author`}, true},
+	{`$.store.book[0, 2].price`, []string{`/store/book/0/price`, `/store/book/2/price`}, true},
+	{`$.store.book[*].author`, []string{`/store/book/0/author`, `/store/book/1/author`, `/store/book/2/author`}, true},
+	{`$.store.*`, []string{`/store/a.b`, `/store/bicycle`, `/store/book`}, true},
+	{`$..price`, []string{`/store/bicycle/price`, `/store/book/0/price`, `/store/book/1/price`, `/store/book/2/price`}, true},
+	{`$.store.book[?(@.isbn)].author`, []string{`/store/book/2/author`}, true},
+	{`$.store.book[?(@.category == 'fiction')]`, []string{`/store/book/1`, `/store/book/2`}, true},
+	{`$.store.book[?(@.price < 9)]`, []string{`/store/book/0`, `/store/book/2`}, true},
+	{`$.store.book[?(@.price >= 12.99)]`, []string{`/store/book/1`}, true},
+	{`$.store.book[?(@.author != "Nigel Rees")]`, []string{`/store/book/1`, `/store/book/2`}, true},
+	{`$.nothing[*]`, []string{}, true},
+	{`/store/book/*/category`, []string{`/store/book/0/category`, `/store/book/1/category`, `/store/book/2/category`}, true},
+	{`/store/*/price`, []string{`/store/bicycle/price`}, true},
+	{`$.store.book[`, nil, false},
+	{`$.store..`, nil, false},
+	{`$store`, nil, false},
+	{`$.store.book[?(@.price ~ 5)]`, nil, false},
+	{`$.store.book[a]`, nil, false},
+	{`store/*`, nil, false},
+}
+
+func TestSelectorPaths(t *testing.T) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(pathDoc), &doc); err != nil {
+		t.Fatalf("Bad test document (%v)", err)
+	}
+	for _, test := range pathTests {
+		path, err := newSelectorPath(test.path)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("`%v` compiled when it should not have", test.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("`%v` did not compile (%v)", test.path, err)
+			continue
+		}
+		found := make([]string, 0)
+		for _, m := range path.find(doc) {
+			found = append(found, m.ptr.String())
+		}
+		if !reflect.DeepEqual(found, test.found) {
+			t.Errorf("`%v` found %#v, not %#v", test.path, found, test.found)
+		}
+	}
+}
+
+func TestSelectorPathsOrdered(t *testing.T) {
+	ordered, err := utils.UnmarshalOrdered([]byte(pathDoc))
+	if err != nil {
+		t.Fatalf("Bad test document (%v)", err)
+	}
+	for _, test := range pathTests {
+		if !test.valid {
+			continue
+		}
+		path, _ := newSelectorPath(test.path)
+		found := make([]string, 0)
+		for _, m := range path.find(ordered) {
+			found = append(found, m.ptr.String())
+		}
+		sort.Strings(found)
+		expected := append([]string{}, test.found...)
+		sort.Strings(expected)
+		if !reflect.DeepEqual(found, expected) {
+			t.Errorf("`%v` found %#v in an ordered document, not %#v", test.path, found, expected)
+		}
+	}
+	path, _ := newSelectorPath(`$.store.*`)
+	found := make([]string, 0)
+	for _, m := range path.find(ordered) {
+		found = append(found, m.ptr.String())
+	}
+	expected := []string{`/store/book`, `/store/bicycle`, `/store/a.b`}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("`$.store.*` found %#v in an ordered document, not %#v", found, expected)
+	}
+}
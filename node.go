@@ -0,0 +1,335 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// Kind is the JSON type of a Node.
+type Kind int
+
+const (
+	NullKind Kind = iota
+	BoolKind
+	NumberKind
+	StringKind
+	ArrayKind
+	ObjectKind
+)
+
+// Node is a value in a document that patches can be applied to.  It
+// lets the apply engine work on documents that are not stored as
+// unmarshalled JSON.  ValueNode is the implementation for unmarshalled
+// JSON and other Go values.
+//
+// Keys are object member names or array indexes, the same as the
+// segments of a Pointer.  Containers are changed in place.
+type Node interface {
+	// Kind returns the JSON type of the node.
+	Kind() Kind
+	// Get returns the member of the node named by key.  It fails if
+	// there is no such member, or the node is not a container.
+	Get(key string) (Node, error)
+	// Set replaces the existing member of the node named by key.
+	Set(key string, val Node) error
+	// Insert adds val to the node as per the add operation: objects
+	// gain or replace the member key, and arrays have val inserted
+	// before index key, or appended if key is `-`.
+	Insert(key string, val Node) error
+	// Delete removes the member of the node named by key.
+	Delete(key string) error
+	// Equal returns true if the node and other hold the same JSON value.
+	Equal(other Node) bool
+	// Clone returns a deep copy of the node that is not part of any
+	// document.
+	Clone() Node
+	// Make returns a new node of the same implementation holding val,
+	// which is the result of unmarshalling JSON.
+	Make(val interface{}) (Node, error)
+}
+
+// nodeAt returns the node ptr points at in root.
+func nodeAt(root Node, ptr Pointer) (Node, error) {
+	var err error
+	for _, seg := range ptr {
+		if root, err = root.Get(string(seg)); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// applyNode performs a single operation on root, returning the new
+// root.  Only operations on the whole document change the root.
+func (o *Operation) applyNode(root Node) (Node, error) {
+	var val Node
+	var err error
+	switch o.Op {
+	case "test", "replace", "add":
+		val, err = root.Make(o.Value)
+	case "move", "copy":
+		if o.Op == "move" && len(o.Path) > len(o.From) && o.Path[:len(o.From)].Equal(o.From) {
+			return root, fmt.Errorf("Cannot move %v into one of its children", o.From.String())
+		}
+		if val, err = nodeAt(root, o.From); err == nil {
+			val = val.Clone()
+		}
+	case "remove":
+	default:
+		return root, fmt.Errorf("Invalid op %v", o.Op)
+	}
+	if err != nil {
+		return root, err
+	}
+	if o.Op == "test" {
+		found, err := nodeAt(root, o.Path)
+		if err == nil && !found.Equal(val) {
+			err = fmt.Errorf("Test op failed.")
+		}
+		return root, err
+	}
+	if o.Op == "move" && len(o.From) > 0 {
+		key, parentPtr := o.From.Chop()
+		parent, err := nodeAt(root, parentPtr)
+		if err != nil {
+			return root, err
+		}
+		if err := parent.Delete(key); err != nil {
+			return root, err
+		}
+	}
+	if len(o.Path) == 0 {
+		if o.Op == "remove" {
+			return root, fmt.Errorf("Cannot remove the whole document")
+		}
+		return val, nil
+	}
+	key, parentPtr := o.Path.Chop()
+	parent, err := nodeAt(root, parentPtr)
+	if err != nil {
+		return root, err
+	}
+	switch o.Op {
+	case "replace":
+		err = parent.Set(key, val)
+	case "remove":
+		err = parent.Delete(key)
+	default:
+		err = parent.Insert(key, val)
+	}
+	return root, err
+}
+
+// ApplyNode applies every operation in p to doc in place, and returns
+// the root of the patched document, which is only different from doc
+// if an operation replaced the whole document.  If err is returned,
+// loc is the index of the operation that failed, and doc may have been
+// partly patched.  Use doc.Clone() first to leave doc alone.
+func (p Patch) ApplyNode(doc Node) (result Node, err error, loc int) {
+	result = doc
+	for i := range p {
+		if result, err = p[i].applyNode(result); err != nil {
+			return result, err, i
+		}
+	}
+	return result, nil, 0
+}
+
+// ValueNode is a Node for documents made of unmarshalled JSON, or of
+// anything else a Pointer can address.  A ValueNode remembers its
+// parent and the key it has there, so that changes made through it can
+// be stored back into the document without walking it from the root.
+// Containers are shared with the document, so changes made in place
+// through other nodes are seen, but a node fetched before an element
+// was inserted into or removed from an array above it may be stale.
+// Fetch nodes again from the root after changing the document.
+type ValueNode struct {
+	parent *ValueNode
+	key    string
+	val    interface{}
+	cmp    *comparer
+}
+
+// NewValueNode returns a node for the root of doc.  Patching the node
+// may change doc in place, and Value returns the patched document.
+// Nodes are compared using Equal with the default options.
+func NewValueNode(doc interface{}) *ValueNode {
+	return newValueNode(doc, &comparer{})
+}
+
+// NewValueNodeWithOptions does the same thing as NewValueNode, except
+// that nodes are compared using Equal with opts.
+func NewValueNodeWithOptions(doc interface{}, opts EqualOptions) (*ValueNode, error) {
+	cmp, err := newComparer(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newValueNode(doc, cmp), nil
+}
+
+func newValueNode(doc interface{}, cmp *comparer) *ValueNode {
+	return &ValueNode{val: doc, cmp: cmp}
+}
+
+// Value returns the value the node refers to.
+func (n *ValueNode) Value() interface{} {
+	return n.val
+}
+
+// pointer returns where the node is in its document.  It is only
+// needed when comparing against patterns, so it is not kept around.
+func (n *ValueNode) pointer() Pointer {
+	if n.parent == nil {
+		return make(Pointer, 0)
+	}
+	return n.parent.pointer().Append(n.key)
+}
+
+// sameContainer returns true if b is the container a, changed in
+// place, so that whatever holds a already holds b.
+func sameContainer(a, b interface{}) bool {
+	switch at := a.(type) {
+	case map[string]interface{}:
+		bt, ok := b.(map[string]interface{})
+		return ok && reflect.ValueOf(at).Pointer() == reflect.ValueOf(bt).Pointer()
+	case []interface{}:
+		bt, ok := b.([]interface{})
+		return ok && len(at) == len(bt) && (len(at) == 0 || &at[0] == &bt[0])
+	case *utils.Object:
+		return at == b
+	}
+	return false
+}
+
+// update makes val the value of the node, and stores it into the
+// parent if the parent does not already hold it.
+func (n *ValueNode) update(val interface{}) error {
+	old := n.val
+	n.val = val
+	if n.parent == nil || sameContainer(old, val) {
+		return nil
+	}
+	res, err := Pointer{pointerSegment(n.key)}.Replace(n.parent.val, val)
+	if err != nil {
+		return err
+	}
+	return n.parent.update(res)
+}
+
+// Kind returns the JSON type of the value the node refers to.
+func (n *ValueNode) Kind() Kind {
+	switch t := n.val.(type) {
+	case nil:
+		return NullKind
+	case bool:
+		return BoolKind
+	case float64, json.Number:
+		return NumberKind
+	case string:
+		return StringKind
+	case []interface{}:
+		return ArrayKind
+	case map[string]interface{}, *utils.Object:
+		return ObjectKind
+	default:
+		v, err := indirect(reflect.ValueOf(t))
+		if err != nil {
+			return NullKind
+		}
+		switch v.Kind() {
+		case reflect.Bool:
+			return BoolKind
+		case reflect.String:
+			return StringKind
+		case reflect.Slice, reflect.Array:
+			return ArrayKind
+		case reflect.Map, reflect.Struct:
+			return ObjectKind
+		default:
+			return NumberKind
+		}
+	}
+}
+
+// Get returns a node for the member of the value named by key.
+func (n *ValueNode) Get(key string) (Node, error) {
+	val, err := Pointer{pointerSegment(key)}.Get(n.val)
+	if err != nil {
+		return nil, err
+	}
+	return &ValueNode{parent: n, key: key, val: val, cmp: n.cmp}, nil
+}
+
+// nodeValue returns the value held by val, which must be a ValueNode.
+func nodeValue(val Node) (interface{}, error) {
+	if v, ok := val.(*ValueNode); ok {
+		return v.Value(), nil
+	}
+	return nil, fmt.Errorf("Cannot store a %T in a ValueNode", val)
+}
+
+// Set replaces the member of the value named by key with the value
+// of val, which must be a ValueNode.
+func (n *ValueNode) Set(key string, val Node) error {
+	v, err := nodeValue(val)
+	if err != nil {
+		return err
+	}
+	res, err := Pointer{pointerSegment(key)}.Replace(n.val, v)
+	if err != nil {
+		return err
+	}
+	return n.update(res)
+}
+
+// Insert adds the value of val, which must be a ValueNode, to the
+// value as per the add operation.
+func (n *ValueNode) Insert(key string, val Node) error {
+	v, err := nodeValue(val)
+	if err != nil {
+		return err
+	}
+	res, err := Pointer{pointerSegment(key)}.Put(n.val, v)
+	if err != nil {
+		return err
+	}
+	return n.update(res)
+}
+
+// Delete removes the member of the value named by key.
+func (n *ValueNode) Delete(key string) error {
+	ptr := Pointer{pointerSegment(key)}
+	res, err := ptr.Remove(n.val)
+	if err != nil {
+		return err
+	}
+	return n.update(res)
+}
+
+// Equal returns true if other is a ValueNode whose value is equal to
+// this one, as compared by the options the node was made with.
+func (n *ValueNode) Equal(other Node) bool {
+	v, err := nodeValue(other)
+	if err != nil {
+		return false
+	}
+	var ptr Pointer
+	if len(n.cmp.unordered) > 0 {
+		ptr = n.pointer()
+	}
+	return n.cmp.equal(n.val, v, ptr)
+}
+
+// Clone returns a node for the root of a deep copy of the value.
+func (n *ValueNode) Clone() Node {
+	return newValueNode(utils.Clone(n.val), n.cmp)
+}
+
+// Make returns a node for the root of a deep copy of val, which is
+// compared using the same options as this node.
+func (n *ValueNode) Make(val interface{}) (Node, error) {
+	return newValueNode(utils.Clone(val), n.cmp), nil
+}
@@ -0,0 +1,220 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// treeNode is a Node that keeps documents in a tree of its own, to
+// check that the apply engine only uses the Node interface.
+type treeNode struct {
+	kind    Kind
+	scalar  interface{}
+	keys    []string
+	members map[string]*treeNode
+	elems   []*treeNode
+}
+
+func makeTree(val interface{}) *treeNode {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		res := &treeNode{kind: ObjectKind, members: map[string]*treeNode{}}
+		for _, k := range sortedKeys(t) {
+			res.keys = append(res.keys, k)
+			res.members[k] = makeTree(t[k])
+		}
+		return res
+	case []interface{}:
+		res := &treeNode{kind: ArrayKind, elems: []*treeNode{}}
+		for i := range t {
+			res.elems = append(res.elems, makeTree(t[i]))
+		}
+		return res
+	case nil:
+		return &treeNode{kind: NullKind}
+	case bool:
+		return &treeNode{kind: BoolKind, scalar: t}
+	case float64:
+		return &treeNode{kind: NumberKind, scalar: t}
+	default:
+		return &treeNode{kind: StringKind, scalar: t}
+	}
+}
+
+func (t *treeNode) value() interface{} {
+	switch t.kind {
+	case ObjectKind:
+		res := map[string]interface{}{}
+		for k, v := range t.members {
+			res[k] = v.value()
+		}
+		return res
+	case ArrayKind:
+		res := []interface{}{}
+		for _, v := range t.elems {
+			res = append(res, v.value())
+		}
+		return res
+	default:
+		return t.scalar
+	}
+}
+
+func (t *treeNode) index(key string, bound int) (int, error) {
+	return normalizeOffset(key, bound)
+}
+
+func (t *treeNode) Kind() Kind {
+	return t.kind
+}
+
+func (t *treeNode) Get(key string) (Node, error) {
+	switch t.kind {
+	case ObjectKind:
+		if res, ok := t.members[key]; ok {
+			return res, nil
+		}
+	case ArrayKind:
+		i, err := t.index(key, len(t.elems))
+		if err != nil {
+			return nil, err
+		}
+		return t.elems[i], nil
+	}
+	return nil, fmt.Errorf("No member %v", key)
+}
+
+func (t *treeNode) Set(key string, val Node) error {
+	if _, err := t.Get(key); err != nil {
+		return err
+	}
+	if t.kind == ArrayKind {
+		i, _ := t.index(key, len(t.elems))
+		t.elems[i] = val.(*treeNode)
+		return nil
+	}
+	return t.Insert(key, val)
+}
+
+func (t *treeNode) Insert(key string, val Node) error {
+	v := val.(*treeNode)
+	switch t.kind {
+	case ObjectKind:
+		if _, ok := t.members[key]; !ok {
+			t.keys = append(t.keys, key)
+		}
+		t.members[key] = v
+		return nil
+	case ArrayKind:
+		i := len(t.elems)
+		if key != "-" && key != strconv.Itoa(i) {
+			var err error
+			if i, err = t.index(key, len(t.elems)); err != nil {
+				return err
+			}
+		}
+		elems := append([]*treeNode{}, t.elems[:i]...)
+		t.elems = append(append(elems, v), t.elems[i:]...)
+		return nil
+	}
+	return fmt.Errorf("Not a container")
+}
+
+func (t *treeNode) Delete(key string) error {
+	if _, err := t.Get(key); err != nil {
+		return err
+	}
+	if t.kind == ObjectKind {
+		delete(t.members, key)
+		for i := range t.keys {
+			if t.keys[i] == key {
+				t.keys = append(t.keys[:i], t.keys[i+1:]...)
+				break
+			}
+		}
+		return nil
+	}
+	i, _ := t.index(key, len(t.elems))
+	t.elems = append(append([]*treeNode{}, t.elems[:i]...), t.elems[i+1:]...)
+	return nil
+}
+
+func (t *treeNode) Equal(other Node) bool {
+	return reflect.DeepEqual(t.value(), other.(*treeNode).value())
+}
+
+func (t *treeNode) Clone() Node {
+	return makeTree(t.value())
+}
+
+func (t *treeNode) Make(val interface{}) (Node, error) {
+	return makeTree(val), nil
+}
+
+func TestApplyNode(t *testing.T) {
+	for _, test := range opTests {
+		var src, final interface{}
+		json.Unmarshal([]byte(test.src), &src)
+		json.Unmarshal([]byte(test.final), &final)
+		p, err := NewPatch([]byte(test.patch))
+		if err != nil {
+			t.Errorf("%v: bad patch (%v)", test.desc, err)
+			continue
+		}
+		for _, root := range []Node{makeTree(src), NewValueNode(src)} {
+			res, err, loc := p.ApplyNode(root.Clone())
+			if !test.pass {
+				if err == nil {
+					t.Errorf("%v: expected %T to fail", test.desc, root)
+				} else if loc != test.failidx {
+					t.Errorf("%v: expected %T to fail at %d, got %d", test.desc, root, test.failidx, loc)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%v: %T failed (%v)", test.desc, root, err)
+				continue
+			}
+			var got interface{}
+			switch r := res.(type) {
+			case *treeNode:
+				got = r.value()
+			case *ValueNode:
+				got = r.Value()
+			}
+			if !reflect.DeepEqual(got, final) {
+				t.Errorf("%v: %T expected %v, got %v", test.desc, root, test.final, got)
+			}
+		}
+	}
+}
+
+func TestValueNodeKinds(t *testing.T) {
+	doc := map[string]interface{}{
+		"null": nil, "bool": true, "number": 1.0, "string": "s",
+		"array": []interface{}{}, "object": map[string]interface{}{},
+		"ints": []int{1}, "struct": struct{ A int }{1},
+	}
+	expected := map[string]Kind{
+		"null": NullKind, "bool": BoolKind, "number": NumberKind, "string": StringKind,
+		"array": ArrayKind, "object": ObjectKind, "ints": ArrayKind, "struct": ObjectKind,
+	}
+	root := NewValueNode(doc)
+	for k, kind := range expected {
+		n, err := root.Get(k)
+		if err != nil {
+			t.Errorf("Failed to get %v: %v", k, err)
+			continue
+		}
+		if n.Kind() != kind {
+			t.Errorf("Expected %v to be kind %v, got %v", k, kind, n.Kind())
+		}
+	}
+	n, _ := root.Get("ints")
+	if n, _ = n.Get("0"); n.Kind() != NumberKind {
+		t.Errorf("Expected typed int to be a number, got %v", n.Kind())
+	}
+}
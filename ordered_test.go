@@ -0,0 +1,75 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+type orderedTest struct {
+	base, patch, result string
+}
+
+var orderedApplyTests = []orderedTest{
+	{
+		`{"z":1,"b":{"y":2,"a":3},"m":[{"q":1,"p":2}]}`,
+		`[{"op":"replace","path":"/b/y","value":4},{"op":"add","path":"/c","value":{"k":1,"e":2}}]`,
+		`{"z":1,"b":{"y":4,"a":3},"m":[{"q":1,"p":2}],"c":{"k":1,"e":2}}`,
+	},
+	{
+		`{"z":1,"b":2,"a":3}`,
+		`[{"op":"remove","path":"/b"},{"op":"add","path":"/z","value":5},{"op":"move","from":"/z","path":"/y"}]`,
+		`{"a":3,"y":5}`,
+	},
+	{
+		`{"z":{"y":1,"x":2}}`,
+		`[{"op":"test","path":"/z","value":{"x":2,"y":1}},{"op":"copy","from":"/z","path":"/a"}]`,
+		`{"z":{"y":1,"x":2},"a":{"y":1,"x":2}}`,
+	},
+}
+
+func TestApplyOrdered(t *testing.T) {
+	for _, test := range orderedApplyTests {
+		res, err, _ := ApplyJSONWithOptions([]byte(test.base), []byte(test.patch), ApplyOptions{PreserveOrder: true})
+		if err != nil {
+			t.Errorf("Failed to apply %v to %v (%v)", test.patch, test.base, err)
+			continue
+		}
+		if string(res) != test.result {
+			t.Errorf("Applying %v: expected %v, got %v", test.patch, test.result, string(res))
+		}
+	}
+}
+
+func TestGenerateOrdered(t *testing.T) {
+	base := `{"z":1,"b":{"y":2,"a":3},"list":[{"id":"a","v":1}]}`
+	target := `{"b":{"a":3,"y":5},"z":1,"new":{"z":1,"a":2},"list":[{"id":"a","v":2}],"another":true}`
+	opts := GenerateOptions{PreserveOrder: true, MergeKeys: map[string]string{"/list": "id"}}
+	p, err := GenerateJSONWithOptions([]byte(base), []byte(target), opts)
+	if err != nil {
+		t.Fatalf("Failed to generate patch: %v", err)
+	}
+	expected := `[{"op":"replace","path":"/b/y","value":5},{"op":"replace","path":"/list/0/v","value":2},{"op":"add","path":"/new","value":{"z":1,"a":2}},{"op":"add","path":"/another","value":true}]`
+	if string(p) != expected {
+		t.Errorf("Expected patch %v, got %v", expected, string(p))
+	}
+	res, err, _ := ApplyJSONWithOptions([]byte(base), p, ApplyOptions{PreserveOrder: true})
+	if err != nil {
+		t.Fatalf("Failed to apply generated patch: %v", err)
+	}
+	expected = `{"z":1,"b":{"y":5,"a":3},"list":[{"id":"a","v":2}],"new":{"z":1,"a":2},"another":true}`
+	if string(res) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(res))
+	}
+}
+
+func TestMergeOrdered(t *testing.T) {
+	res, err := utils.MergeJSONOrdered([]byte(`{"z":1,"b":{"y":2,"a":3},"c":4}`), []byte(`{"c":null,"b":{"y":null,"n":{"q":1,"p":null}},"x":2,"d":{"k":1}}`))
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	expected := `{"z":1,"b":{"a":3,"n":{"q":1,"p":null}},"x":2,"d":{"k":1}}`
+	if string(res) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(res))
+	}
+}
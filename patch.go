@@ -18,8 +18,8 @@ import (
 	"github.com/VictorLowther/jsonpatch/utils"
 )
 
-// operation represents a valid JSON Patch operation as defined by RFC 6902
-type operation struct {
+// Operation represents a valid JSON Patch operation as defined by RFC 6902
+type Operation struct {
 	// Op can be one of:
 	//    * "add"
 	//    * "remove"
@@ -31,15 +31,15 @@ type operation struct {
 	Op string `json:"op"`
 	// Path is a JSON Pointer as defined in RFC 6901
 	// All Operations must have a Path
-	Path pointer `json:"path"`
+	Path Pointer `json:"path"`
 	// From is a JSON pointer indicating where a value should be
 	// copied/moved from.  From is only used by copy and move operations.
-	From pointer `json:"from"`
+	From Pointer `json:"from"`
 	// Value is the Value to be used for add, replace, and test operations.
 	Value interface{} `json:"value"`
 }
 
-func (o *operation) MarshalJSON() ([]byte, error) {
+func (o *Operation) MarshalJSON() ([]byte, error) {
 	res := map[string]interface{}{}
 	res["op"] = o.Op
 	res["path"] = o.Path
@@ -55,7 +55,7 @@ func (o *operation) MarshalJSON() ([]byte, error) {
 const ContentType = "application/json-patch+json"
 
 // Apply performs a single patch operation
-func (o *operation) Apply(to interface{}) (interface{}, error) {
+func (o *Operation) Apply(to interface{}) (interface{}, error) {
 	switch o.Op {
 	case "test":
 		return to, o.Path.Test(to, o.Value)
@@ -75,18 +75,22 @@ func (o *operation) Apply(to interface{}) (interface{}, error) {
 }
 
 // Patch is an array of individual JSON Patch operations.
-type patch []operation
+type Patch []Operation
 
 // NewPatch takes a byte array and tries to unmarshal it.
-func newPatch(buf []byte) (res patch, err error) {
-	res = make(patch, 0)
+func NewPatch(buf []byte) (res Patch, err error) {
+	res = make(Patch, 0)
 	if err = json.Unmarshal(buf, &res); err != nil {
 		return nil, err
 	}
+	return res, res.validate()
+}
 
-	for _, op := range res {
+// validate checks that every operation in p has what it needs.
+func (p Patch) validate() error {
+	for _, op := range p {
 		if op.Path == nil {
-			return res, fmt.Errorf("Did not get valid path")
+			return fmt.Errorf("Did not get valid path")
 		}
 		switch op.Op {
 		case "test":
@@ -95,22 +99,59 @@ func newPatch(buf []byte) (res patch, err error) {
 			fallthrough
 		case "add":
 			if op.Value == nil {
-				return res, fmt.Errorf("%v must have a valid value", op.Op)
+				return fmt.Errorf("%v must have a valid value", op.Op)
 
 			}
 		case "move":
 			fallthrough
 		case "copy":
 			if op.From == nil {
-				return res, fmt.Errorf("%v must have a from", op.Op)
+				return fmt.Errorf("%v must have a from", op.Op)
 			}
 		case "remove":
 			continue
 		default:
-			return res, fmt.Errorf("%v is not a valid JSON Patch operator", op.Op)
+			return fmt.Errorf("%v is not a valid JSON Patch operator", op.Op)
 		}
 	}
-	return res, nil
+	return nil
+}
+
+// ApplyOptions holds hooks that are called as each operation in a
+// patch is applied.  They can be used to audit, authorize, or collect
+// metrics on changes without having to reimplement Apply.  It also
+// holds settings for how patches are applied.
+type ApplyOptions struct {
+	// BeforeOp, if set, is called with each operation and the
+	// document it is about to be applied to.  doc must not be
+	// modified.  If BeforeOp returns an error, the operation is not
+	// applied, and applying the patch fails with that error.
+	BeforeOp func(op *Operation, doc interface{}) error
+	// AfterOp, if set, is called after each operation has been
+	// applied with the values at the operation's path before and
+	// after it was applied.  oldValue is nil if there was nothing
+	// there, which includes operations that insert an element into
+	// an array, and newValue is nil if there is nothing there now.
+	// For move operations, the values are those at Path, not From.
+	AfterOp func(op *Operation, oldValue, newValue interface{})
+	// Schema, if set, is used to validate the patched document.  If
+	// it does not match, applying the patch fails with a
+	// *ValidationError, and each SchemaError in it has the index of
+	// the last operation that touched the value that failed.
+	Schema *Schema
+	// PreserveOrder makes ApplyJSONWithOptions keep the order of
+	// keys in objects, so that untouched keys stay where they were
+	// and new keys are added at the end.  Objects in the document
+	// passed to the hooks are *utils.Object instead of
+	// map[string]interface{}.
+	PreserveOrder bool
+	// PreserveFormat makes ApplyJSONWithOptions change only the
+	// bytes of the document that hold values the patch changed, so
+	// that indentation, whitespace, and the way numbers are written
+	// stay the same everywhere else.  It implies PreserveOrder.
+	PreserveFormat bool
+	// Equal controls how test operations compare values.
+	Equal EqualOptions
 }
 
 // Apply applies rawPatch (which must be a []byte containing a valid
@@ -121,16 +162,87 @@ func newPatch(buf []byte) (res patch, err error) {
 // base must be the result of unmarshaling JSON to interface{}, and
 // will not be modified.
 func Apply(base interface{}, rawPatch []byte) (result interface{}, err error, loc int) {
-	p, err := newPatch(rawPatch)
+	return ApplyWithOptions(base, rawPatch, ApplyOptions{})
+}
+
+// ApplyWithOptions does the same thing as Apply, except that the hooks
+// in opts are called for each operation.
+func ApplyWithOptions(base interface{}, rawPatch []byte, opts ApplyOptions) (result interface{}, err error, loc int) {
+	p, err := NewPatch(rawPatch)
 	if err != nil {
 		return nil, err, 0
 	}
+	return p.Apply(base, opts)
+}
+
+// insertsElement returns true if o adds a new element to an array in
+// root, rather than setting whatever is at its path.
+func (o *Operation) insertsElement(root Node) bool {
+	switch o.Op {
+	case "add", "move", "copy":
+	default:
+		return false
+	}
+	if len(o.Path) == 0 {
+		return false
+	}
+	parent, err := nodeAt(root, o.Path[:len(o.Path)-1])
+	return err == nil && parent.Kind() == ArrayKind
+}
+
+// Apply applies every operation in p to a copy of base, calling the
+// hooks in opts for each of them.  If err is returned, loc is the
+// index of the operation that failed.  If the patched document does not
+// match opts.Schema, loc is the index of the first operation that a
+// schema error is attributed to, or 0 if none were.
+func (p Patch) Apply(base interface{}, opts ApplyOptions) (result interface{}, err error, loc int) {
+	cmp, err := newComparer(opts.Equal)
+	if err != nil {
+		return base, err, 0
+	}
 	result = utils.Clone(base)
-	for i, op := range p {
-		result, err = op.Apply(result)
+	var root Node = newValueNode(result, cmp)
+	for i := range p {
+		op := &p[i]
+		if opts.BeforeOp != nil {
+			if err = opts.BeforeOp(op, result); err != nil {
+				return result, err, i
+			}
+		}
+		var oldValue, newValue interface{}
+		if opts.AfterOp != nil {
+			if !op.insertsElement(root) {
+				oldValue, _ = op.Path.Get(result)
+			}
+			if op.Op == "move" || op.Op == "copy" {
+				newValue, _ = op.From.Get(result)
+			}
+		}
+		root, err = op.applyNode(root)
+		result = root.(*ValueNode).Value()
 		if err != nil {
 			return result, err, i
 		}
+		if opts.AfterOp != nil {
+			switch op.Op {
+			case "remove", "move", "copy":
+			default:
+				newValue, _ = op.Path.Get(result)
+			}
+			opts.AfterOp(op, oldValue, newValue)
+		}
+	}
+	if opts.Schema != nil {
+		if errs := opts.Schema.Validate(result); len(errs) > 0 {
+			attribute(errs, p)
+			for i := range errs {
+				if errs[i].Op != -1 {
+					loc = errs[i].Op
+					break
+				}
+			}
+			return result, &ValidationError{errs}, loc
+		}
 	}
 	return result, nil, 0
 }
@@ -138,15 +250,61 @@ func Apply(base interface{}, rawPatch []byte) (result interface{}, err error, lo
 // ApplyJSON does the same thing as Apply, except the inputs should be
 // JSON-containing byte arrays instead of unmarshalled JSON
 func ApplyJSON(base, rawPatch []byte) (result []byte, err error, loc int) {
+	return ApplyJSONWithOptions(base, rawPatch, ApplyOptions{})
+}
+
+// orderValues replaces the values of the operations in p with ones
+// that keep the order of keys they have in rawPatch.
+func (p Patch) orderValues(rawPatch []byte) error {
+	raw, err := utils.UnmarshalOrdered(rawPatch)
+	if err != nil {
+		return err
+	}
+	ops, _ := raw.([]interface{})
+	for i := range p {
+		if i >= len(ops) {
+			break
+		}
+		if obj, ok := ops[i].(*utils.Object); ok {
+			if val, ok := obj.Get("value"); ok {
+				p[i].Value = val
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyJSONWithOptions does the same thing as ApplyWithOptions, except
+// the inputs should be JSON-containing byte arrays instead of
+// unmarshalled JSON
+func ApplyJSONWithOptions(base, rawPatch []byte, opts ApplyOptions) (result []byte, err error, loc int) {
 	var rawBase interface{}
-	err = json.Unmarshal(base, &rawBase)
+	ordered := opts.PreserveOrder || opts.PreserveFormat
+	if ordered {
+		rawBase, err = utils.UnmarshalOrdered(base)
+	} else {
+		err = json.Unmarshal(base, &rawBase)
+	}
+	if err != nil {
+		return nil, err, 0
+	}
+	p, err := NewPatch(rawPatch)
 	if err != nil {
 		return nil, err, 0
 	}
-	rawRes, err, loc := Apply(rawBase, rawPatch)
+	if ordered {
+		if err = p.orderValues(rawPatch); err != nil {
+			return nil, err, 0
+		}
+	}
+	rawRes, err, loc := p.Apply(rawBase, opts)
 	if err != nil {
 		return nil, err, loc
 	}
+	if opts.PreserveFormat {
+		result, err = splice(base, rawBase, rawRes)
+		return result, err, loc
+	}
 	result, err = json.Marshal(rawRes)
 	return result, err, loc
 }
@@ -2,6 +2,7 @@ package jsonpatch
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 )
@@ -357,6 +358,24 @@ var opTests = []opTest{
 		0,
 		false,
 	},
+	{
+		`Move test 4`,
+		`{"foo":[1,2,3]}`,
+		`{"foo":[3,1,2]}`,
+		`[{"op":"move","from":"/foo/2","path":"/foo/0"}]`,
+		true,
+		0,
+		false,
+	},
+	{
+		`Move test 5`,
+		`{"foo":[1,2,3]}`,
+		`{"foo":[2,3,1]}`,
+		`[{"op":"move","from":"/foo/0","path":"/foo/2"}]`,
+		true,
+		0,
+		false,
+	},
 	// Replace tests
 	{
 		`Replace test 1`,
@@ -438,7 +457,7 @@ func TestPatches(t *testing.T) {
 				t.Errorf("Expected patch `%v` to fail at operation %v, but it passed.", test.patch, idx)
 				continue
 			} else if idx != test.failidx {
-				t.Errorf("Expected patch `%v` to fail at operation ~v, but it failed at %v instead!", test.patch, test.failidx, idx)
+				t.Errorf("Expected patch `%v` to fail at operation %v, but it failed at %v instead!", test.patch, test.failidx, idx)
 				continue
 			}
 		}
@@ -451,7 +470,7 @@ func TestPatches(t *testing.T) {
 			continue
 		}
 
-		var rawRefPatch, rawGenPatch patch
+		var rawRefPatch, rawGenPatch Patch
 		if json.Unmarshal([]byte(test.patch), &rawRefPatch) != nil {
 			t.Errorf("Did not expect to fail to unmarshal reference patch `%v`", test.patch)
 			continue
@@ -480,3 +499,67 @@ func TestPatches(t *testing.T) {
 		}
 	}
 }
+
+type hookCall struct {
+	Op       string
+	Path     string
+	OldValue interface{}
+	NewValue interface{}
+}
+
+func TestApplyHooks(t *testing.T) {
+	var src interface{}
+	json.Unmarshal([]byte(`{"spec":{"a":1,"l":[1,2]},"metadata":{"owner":"me"}}`), &src)
+	calls := make([]hookCall, 0)
+	opts := ApplyOptions{
+		BeforeOp: func(op *Operation, doc interface{}) error {
+			if len(op.Path) > 0 && op.Path[0] == "metadata" {
+				return fmt.Errorf("%v is read-only", op.Path.String())
+			}
+			return nil
+		},
+		AfterOp: func(op *Operation, oldValue, newValue interface{}) {
+			calls = append(calls, hookCall{op.Op, op.Path.String(), oldValue, newValue})
+		},
+	}
+	p := `[
+		{"op":"test","path":"/spec/a","value":1},
+		{"op":"replace","path":"/spec/a","value":2},
+		{"op":"add","path":"/spec/b","value":3},
+		{"op":"remove","path":"/spec/l/0"},
+		{"op":"add","path":"/spec/l/0","value":4},
+		{"op":"move","from":"/spec/b","path":"/spec/c"},
+		{"op":"move","from":"/spec/a","path":"/spec/l/1"}
+	]`
+	res, err, idx := ApplyWithOptions(src, []byte(p), opts)
+	if err != nil {
+		t.Fatalf("Failed to apply patch at operation %v (%v)", idx, err)
+	}
+	expected := []hookCall{
+		{"test", "/spec/a", float64(1), float64(1)},
+		{"replace", "/spec/a", float64(1), float64(2)},
+		{"add", "/spec/b", nil, float64(3)},
+		{"remove", "/spec/l/0", float64(1), nil},
+		{"add", "/spec/l/0", nil, float64(4)},
+		{"move", "/spec/c", nil, float64(3)},
+		{"move", "/spec/l/1", nil, float64(2)},
+	}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Hooks were called with %#v, not %#v", calls, expected)
+	}
+	var final interface{}
+	json.Unmarshal([]byte(`{"spec":{"c":3,"l":[4,2,2]},"metadata":{"owner":"me"}}`), &final)
+	if !reflect.DeepEqual(res, final) {
+		t.Errorf("Applying with hooks gave %#v", res)
+	}
+
+	calls = calls[:0]
+	p = `[{"op":"replace","path":"/spec/a","value":2},{"op":"replace","path":"/metadata/owner","value":"you"}]`
+	_, err, idx = ApplyWithOptions(src, []byte(p), opts)
+	if err == nil || idx != 1 {
+		t.Errorf("Expected BeforeOp to veto operation 1, got %v at %v", err, idx)
+	}
+	if len(calls) != 1 {
+		t.Errorf("AfterOp should have only been called for the operation that was applied, got %#v", calls)
+	}
+}
@@ -0,0 +1,49 @@
+package jsonpatch
+
+// pattern is a JSON pointer in which a segment that is exactly `*`
+// matches any single object member name or array index.  There is no
+// way to match only a member literally named `*`; the wildcard will
+// match it along with everything else.
+type pattern []pointerSegment
+
+// newPattern takes a string that conforms to RFC6901, with `*`
+// segments allowed as wildcards, and turns it into a pattern.
+func newPattern(s string) (pattern, error) {
+	ptr, err := NewPointer(s)
+	if err != nil {
+		return nil, err
+	}
+	return pattern(ptr), nil
+}
+
+// newPatterns turns a list of strings into patterns.
+func newPatterns(src []string) ([]pattern, error) {
+	res := make([]pattern, len(src))
+	for i := range src {
+		pat, err := newPattern(src[i])
+		if err != nil {
+			return nil, err
+		}
+		res[i] = pat
+	}
+	return res, nil
+}
+
+// String returns the string form of the pattern.
+func (p pattern) String() string {
+	return Pointer(p).String()
+}
+
+// Matches returns true if ptr is matched by the pattern.  Matching is
+// exact: a pattern does not match the descendants of what it points at.
+func (p pattern) Matches(ptr Pointer) bool {
+	if len(p) != len(ptr) {
+		return false
+	}
+	for i := range p {
+		if p[i] != "*" && p[i] != ptr[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -3,10 +3,13 @@ package jsonpatch
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/VictorLowther/jsonpatch/internal/docutil"
 	"github.com/VictorLowther/jsonpatch/utils"
 )
 
@@ -35,13 +38,13 @@ func newSegment(s string) (pointerSegment, error) {
 	return pointerSegment(decode.Replace(s)), nil
 }
 
-// pointer is a JSON pointer
-type pointer []pointerSegment
+// Pointer is a JSON pointer as defined in RFC 6901.
+type Pointer []pointerSegment
 
-// newpointer takes a string that conforms to RFC6901 and turns it into a JSON pointer.
-func newPointer(s string) (pointer, error) {
+// NewPointer takes a string that conforms to RFC6901 and turns it into a JSON pointer.
+func NewPointer(s string) (Pointer, error) {
 	frags := strings.Split(s, `/`)[1:]
-	res := make(pointer, len(frags))
+	res := make(Pointer, len(frags))
 	// An empty pointer refers to the whole document, and so is valid.
 	if s == "" {
 		return res, nil
@@ -60,23 +63,23 @@ func newPointer(s string) (pointer, error) {
 }
 
 // Allow a pointer to be marshalled to valid JSON.
-func (p pointer) MarshalJSON() ([]byte, error) {
+func (p Pointer) MarshalJSON() ([]byte, error) {
 	return json.Marshal(p.String())
 }
 
 // Allow unmarshalling from JSON
-func (p *pointer) UnmarshalJSON(buf []byte) error {
+func (p *Pointer) UnmarshalJSON(buf []byte) error {
 	var b string
 	if err := json.Unmarshal(buf, &b); err != nil {
 		return err
 	}
-	ptr, err := newPointer(b)
+	ptr, err := NewPointer(b)
 	*p = ptr[:]
 	return err
 }
 
 // String takes a pointer and returns its string value.
-func (p pointer) String() string {
+func (p Pointer) String() string {
 	frags := make([]string, len(p)+1)
 	for i, frag := range p {
 		frags[i+1] = frag.String()
@@ -84,44 +87,90 @@ func (p pointer) String() string {
 	return strings.Join(frags, `/`)
 }
 
+// fragmentSafe holds the bytes that can appear in a URI fragment
+// without being percent-encoded, as per RFC 3986 section 3.5.
+const fragmentSafe = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-._~!$&'()*+,;=:@/?"
+
+// Fragment returns the URI fragment identifier representation of the
+// pointer, as described in RFC 6901 section 6, including the leading `#`.
+func (p Pointer) Fragment() string {
+	s := p.String()
+	res := make([]byte, 1, len(s)+1)
+	res[0] = '#'
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(fragmentSafe, s[i]) != -1 {
+			res = append(res, s[i])
+			continue
+		}
+		res = append(res, '%', "0123456789ABCDEF"[s[i]>>4], "0123456789ABCDEF"[s[i]&15])
+	}
+	return string(res)
+}
+
+// NewPointerFromFragment takes a URI fragment identifier that contains a
+// JSON pointer, as described in RFC 6901 section 6, and turns it into a
+// JSON pointer.  The fragment must include the leading `#`.
+func NewPointerFromFragment(s string) (Pointer, error) {
+	if !strings.HasPrefix(s, "#") {
+		return nil, fmt.Errorf("URI fragment `%v` must start with `#`", s)
+	}
+	decoded, err := url.PathUnescape(s[1:])
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.ValidString(decoded) {
+		return nil, fmt.Errorf("URI fragment `%v` does not decode to valid UTF-8", s)
+	}
+	return NewPointer(decoded)
+}
+
 // Shift extracts the first element in the pointer, returning it and the rest of the pointer.
-func (p pointer) Shift() (string, pointer) {
+func (p Pointer) Shift() (string, Pointer) {
 	if len(p) == 0 {
-		panic("Cannot shift empty jsonpatch.pointer")
+		panic("Cannot shift empty jsonpatch.Pointer")
 	}
-	return string(p[0]), pointer(p[1:])
+	return string(p[0]), Pointer(p[1:])
 }
 
 // Chop extracts the last element in the pointer, returning it and the rest of the pointer.
-func (p pointer) Chop() (string, pointer) {
+func (p Pointer) Chop() (string, Pointer) {
 	if len(p) == 0 {
-		panic("Cannot chop empty jsonpatch.pointer")
+		panic("Cannot chop empty jsonpatch.Pointer")
 	}
 	last := len(p) - 1
-	return string(p[last]), pointer(p[:last])
+	return string(p[last]), Pointer(p[:last])
 }
 
-func (p pointer) Append(frag string) pointer {
-	return append(p, pointerSegment(decode.Replace(frag)))
+// Equal returns true if p and other point at the same location.
+func (p Pointer) Equal(other Pointer) bool {
+	if len(p) != len(other) {
+		return false
+	}
+	for i := range p {
+		if p[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Append returns a new pointer with frag added to the end.  p is not
+// modified, and the result never shares storage with it.
+func (p Pointer) Append(frag string) Pointer {
+	res := make(Pointer, len(p), len(p)+1)
+	copy(res, p)
+	return append(res, pointerSegment(frag))
 }
 
 func normalizeOffset(selector string, bound int) (int, error) {
-	res, err := strconv.Atoi(selector)
-	if err != nil {
-		return -1, err
-	}
-	if res < 0 {
-		res = bound + res
-	}
-	if res >= bound || res < 0 {
-		return -1, fmt.Errorf("Index out of bounds")
-	}
-	return res, nil
+	return docutil.NormalizeOffset(selector, bound)
 }
 
 // Get takes an unmarshalled JSON blob, and returns the value pointed at by the pointer.
-// The unmarshalled blob is left unchanged.
-func (p pointer) Get(from interface{}) (interface{}, error) {
+// The unmarshalled blob is left unchanged.  It can also be built out
+// of typed maps, slices, arrays, and structs, whose fields are named
+// the same way encoding/json names them.
+func (p Pointer) Get(from interface{}) (interface{}, error) {
 	if len(p) == 0 {
 		return from, nil
 	}
@@ -133,19 +182,30 @@ func (p pointer) Get(from interface{}) (interface{}, error) {
 			return nil, fmt.Errorf("Selector %v not a member of %#v", selector, t)
 		}
 		return nextPointer.Get(found)
+	case *utils.Object:
+		found, ok := t.Get(selector)
+		if !ok {
+			return nil, fmt.Errorf("Selector %v not a member of %v", selector, p.String())
+		}
+		return nextPointer.Get(found)
 	case []interface{}:
 		index, err := normalizeOffset(selector, len(t))
 		if err != nil {
 			return nil, err
 		}
 		return nextPointer.Get(t[index])
-	default:
+	case nil, bool, float64, string:
 		return nil, fmt.Errorf("Cannot index pointer %v for non-indexable JSON value", p.String())
+	default:
+		found, err := reflectChild(reflect.ValueOf(from), selector)
+		if err != nil {
+			return nil, err
+		}
+		return nextPointer.Get(found.Interface())
 	}
-	return nil, fmt.Errorf("Cannot happen")
 }
 
-func (p pointer) toContainer(to interface{}) (string, interface{}, error) {
+func (p Pointer) toContainer(to interface{}) (string, interface{}, error) {
 	if len(p) == 0 {
 		return "", nil, fmt.Errorf("Cannot happen")
 	}
@@ -155,7 +215,7 @@ func (p pointer) toContainer(to interface{}) (string, interface{}, error) {
 }
 
 // Replace replaces the pointed at value (which must exist) with val.
-func (p pointer) Replace(to interface{}, val interface{}) (interface{}, error) {
+func (p Pointer) Replace(to interface{}, val interface{}) (interface{}, error) {
 	if len(p) == 0 {
 		return val, nil
 	}
@@ -170,19 +230,30 @@ func (p pointer) Replace(to interface{}, val interface{}) (interface{}, error) {
 		} else {
 			return to, fmt.Errorf("%v does not refer to an existing location", p.String())
 		}
+	case *utils.Object:
+		if _, ok := t.Get(selector); !ok {
+			return to, fmt.Errorf("%v does not refer to an existing location", p.String())
+		}
+		t.Set(selector, val)
 	case []interface{}:
 		index, err := normalizeOffset(selector, len(t))
 		if err != nil {
 			return to, err
 		}
 		t[index] = val
-	default:
+	case nil, bool, float64, string:
 		return to, fmt.Errorf("Cannot put to non-indexable JSON value")
+	default:
+		res, err := reflectUpdate(reflect.ValueOf(to), p, reflectReplace(val))
+		if err != nil {
+			return to, err
+		}
+		return res.Interface(), nil
 	}
 	return to, nil
 }
 
-func (p pointer) handleChangedSlice(to interface{}, s []interface{}) (interface{}, error) {
+func (p Pointer) handleChangedSlice(to interface{}, s []interface{}) (interface{}, error) {
 	if len(p) > 1 {
 		_, holdPtr := p.Chop()
 		return holdPtr.Replace(to, s)
@@ -197,7 +268,7 @@ func (p pointer) handleChangedSlice(to interface{}, s []interface{}) (interface{
 //
 // Put may have to return a new to if to happens to be a slice, since
 // the semantics of Put necessarily involve growing the Slice.
-func (p pointer) Put(to interface{}, val interface{}) (interface{}, error) {
+func (p Pointer) Put(to interface{}, val interface{}) (interface{}, error) {
 	selector, operatrix, err := p.toContainer(to)
 	if err != nil {
 		return to, err
@@ -205,8 +276,12 @@ func (p pointer) Put(to interface{}, val interface{}) (interface{}, error) {
 	switch t := operatrix.(type) {
 	case map[string]interface{}:
 		t[selector] = val
+	case *utils.Object:
+		t.Set(selector, val)
 	case []interface{}:
-		if selector == "-" {
+		// RFC 6902 allows an index one past the end of the array,
+		// which means the same thing as "-".
+		if selector == "-" || selector == strconv.Itoa(len(t)) {
 			t = append(t, val)
 		} else {
 			index, err := normalizeOffset(selector, len(t))
@@ -221,8 +296,14 @@ func (p pointer) Put(to interface{}, val interface{}) (interface{}, error) {
 			t = res
 		}
 		return p.handleChangedSlice(to, t)
-	default:
+	case nil, bool, float64, string:
 		return to, fmt.Errorf("Cannot put to non-indexable JSON value")
+	default:
+		res, err := reflectUpdate(reflect.ValueOf(to), p, reflectPut(val))
+		if err != nil {
+			return to, err
+		}
+		return res.Interface(), nil
 	}
 	return to, nil
 }
@@ -233,7 +314,7 @@ func (p pointer) Put(to interface{}, val interface{}) (interface{}, error) {
 // Remove may have to return a new from if it is a slice, because the
 // semantics for Reomve on a Slice involve shrinking it, which
 // involves reallocation the way we do it.
-func (p *pointer) Remove(from interface{}) (interface{}, error) {
+func (p *Pointer) Remove(from interface{}) (interface{}, error) {
 	selector, operatrix, err := p.toContainer(from)
 	if err != nil {
 		return from, err
@@ -244,6 +325,10 @@ func (p *pointer) Remove(from interface{}) (interface{}, error) {
 			return from, fmt.Errorf("`%v` does not point to an existing location", p.String())
 		}
 		delete(t, selector)
+	case *utils.Object:
+		if !t.Delete(selector) {
+			return from, fmt.Errorf("`%v` does not point to an existing location", p.String())
+		}
 	case []interface{}:
 		index, err := normalizeOffset(selector, len(t))
 		if err != nil {
@@ -255,14 +340,20 @@ func (p *pointer) Remove(from interface{}) (interface{}, error) {
 		copy(k, k2)
 		t = t[:len(t)-1]
 		return p.handleChangedSlice(from, t)
-	default:
+	case nil, bool, float64, string:
 		return from, fmt.Errorf("Cannot remove non-indexable JSON value")
+	default:
+		res, err := reflectUpdate(reflect.ValueOf(from), *p, reflectRemove)
+		if err != nil {
+			return from, err
+		}
+		return res.Interface(), nil
 	}
 	return from, nil
 }
 
 // Copy deep-copies the value pointed to by p in from to the location pointed to by at.
-func (p pointer) Copy(from interface{}, at pointer) (interface{}, error) {
+func (p Pointer) Copy(from interface{}, at Pointer) (interface{}, error) {
 	val, err := p.Get(from)
 	if err != nil {
 		return from, err
@@ -271,22 +362,38 @@ func (p pointer) Copy(from interface{}, at pointer) (interface{}, error) {
 }
 
 // Move moves the value pointed to by p in from to the location pointed to by at.
-func (p pointer) Move(from interface{}, at pointer) (interface{}, error) {
+// As per RFC 6902, this is the same as removing the value and then
+// adding it at the new location, and at may not be a child of p.
+func (p Pointer) Move(from interface{}, at Pointer) (interface{}, error) {
+	if len(at) > len(p) && at[:len(p)].Equal(p) {
+		return from, fmt.Errorf("Cannot move %v into one of its children", p.String())
+	}
 	val, err := p.Get(from)
 	if err != nil {
 		return from, err
 	}
-	val, err = at.Put(from, val)
+	from, err = p.Remove(from)
 	if err != nil {
-		return val, err
+		return from, err
 	}
-	return p.Remove(val)
+	return at.Put(from, val)
 }
 
-func (p *pointer) Test(from interface{}, sample interface{}) error {
+// Test returns an error if the value pointed to by p in from is not
+// the same as sample, as determined by Equal with the default options.
+func (p *Pointer) Test(from interface{}, sample interface{}) error {
 	val, err := p.Get(from)
-	if err == nil && !reflect.DeepEqual(val, sample) {
+	if err == nil && !Equal(val, sample, EqualOptions{}) {
 		err = fmt.Errorf("Test op failed.")
 	}
 	return err
 }
+
+// unordered returns a copy of the members of val if it is a
+// *utils.Object, and val otherwise.
+func unordered(val interface{}) interface{} {
+	if obj, ok := val.(*utils.Object); ok {
+		return obj.Map()
+	}
+	return val
+}
@@ -25,7 +25,7 @@ var ptrTests = []ptrTest{
 	{`foo/a~1b/c%d/~//~0`, []string{`foo`, `a/b`, `c%d`, ``, ``, `~`}, 6, false},
 }
 
-func ptrEqual(sample pointer, target []string) bool {
+func ptrEqual(sample Pointer, target []string) bool {
 	if len(sample) != len(target) {
 		return false
 	}
@@ -39,7 +39,7 @@ func ptrEqual(sample pointer, target []string) bool {
 
 func TestPointers(t *testing.T) {
 	for _, test := range ptrTests {
-		res, err := newPointer(test.sample)
+		res, err := NewPointer(test.sample)
 		if test.valid {
 			if err != nil {
 				t.Errorf("`%v` did not create pointer! (%v)", test.sample, err)
@@ -63,3 +63,47 @@ func TestPointers(t *testing.T) {
 		}
 	}
 }
+
+type fragTest struct {
+	fragment string
+	pointer  string
+}
+
+// These are the examples from RFC 6901 section 6, plus some non-ASCII.
+var fragTests = []fragTest{
+	{`#`, ``},
+	{`#/foo`, `/foo`},
+	{`#/foo/0`, `/foo/0`},
+	{`#/`, `/`},
+	{`#/a~1b`, `/a~1b`},
+	{`#/c%25d`, `/c%d`},
+	{`#/e%5Ef`, `/e^f`},
+	{`#/g%7Ch`, `/g|h`},
+	{`#/i%5Cj`, `/i\j`},
+	{`#/k%22l`, `/k"l`},
+	{`#/%20`, `/ `},
+	{`#/m~0n`, `/m~0n`},
+	{`#/%C3%A9t%C3%A9`, `/été`},
+	{`#/a%23b/c?d`, `/a#b/c?d`},
+}
+
+func TestFragments(t *testing.T) {
+	for _, test := range fragTests {
+		ptr, err := NewPointerFromFragment(test.fragment)
+		if err != nil {
+			t.Errorf("`%v` did not create pointer! (%v)", test.fragment, err)
+			continue
+		}
+		if ptr.String() != test.pointer {
+			t.Errorf("`%v` created pointer `%v`, not `%v`", test.fragment, ptr.String(), test.pointer)
+		}
+		if frag := ptr.Fragment(); frag != test.fragment {
+			t.Errorf("Pointer `%v` has fragment `%v`, not `%v`", test.pointer, frag, test.fragment)
+		}
+	}
+	for _, bad := range []string{`/foo`, `#foo`, `#/a%2`, `#/%zz`, `#/%FF`, `#/~2`} {
+		if _, err := NewPointerFromFragment(bad); err == nil {
+			t.Errorf("`%v` created a pointer when it should not have!", bad)
+		}
+	}
+}
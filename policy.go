@@ -0,0 +1,156 @@
+package jsonpatch
+
+import "fmt"
+
+// Rule allows or denies operations on part of a document.
+type Rule struct {
+	// Prefix is a JSON pointer to the part of the document the rule
+	// covers.  A segment that is just `*` matches any member name or
+	// array index.
+	Prefix string
+	// Ops is the list of operation types the rule covers, such as
+	// "replace" or "add".  An empty list covers every type.
+	Ops []string
+	// Deny makes the rule forbid what it covers instead of allowing it.
+	Deny bool
+}
+
+// Policy decides which operations a patch is allowed to contain.
+//
+// An operation is checked against its path, and move and copy
+// operations are also checked against their from.  An allow rule
+// covers a location if the location is at or under the rule's Prefix.
+// A deny rule covers a location if the location is at, under, or
+// above the rule's Prefix, since replacing or removing a parent
+// changes everything in it.  An operation is allowed if every location
+// it is checked against is covered by an allow rule and none is
+// covered by a deny rule.
+//
+// So, to let clients replace anything under /spec except its owner:
+//
+//	Policy{Rules: []Rule{
+//		{Prefix: "/spec", Ops: []string{"replace"}},
+//		{Prefix: "/spec/owner", Deny: true},
+//	}}
+type Policy struct {
+	Rules []Rule
+}
+
+// Violation is an operation in a patch that a Policy does not allow.
+type Violation struct {
+	// Index is the index of the operation in the patch.
+	Index int
+	// Op is the operation itself.
+	Op Operation
+	// Reason says why the operation is not allowed.
+	Reason string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("Operation %v (%v %v) is not allowed: %v", v.Index, v.Op.Op, v.Op.Path.String(), v.Reason)
+}
+
+// compiledRule is a Rule with its prefix parsed.
+type compiledRule struct {
+	Rule
+	prefix pattern
+}
+
+// covers returns true if the rule covers op at ptr.
+func (r *compiledRule) covers(op string, ptr Pointer) bool {
+	if len(r.Ops) > 0 {
+		found := false
+		for _, o := range r.Ops {
+			if o == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	n := len(r.prefix)
+	if len(ptr) < n {
+		if !r.Deny {
+			return false
+		}
+		n = len(ptr)
+	}
+	return r.prefix[:n].Matches(ptr[:n])
+}
+
+func (p Policy) compile() ([]compiledRule, error) {
+	res := make([]compiledRule, len(p.Rules))
+	for i, rule := range p.Rules {
+		prefix, err := newPattern(rule.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %v: %v", i, err)
+		}
+		res[i] = compiledRule{rule, prefix}
+	}
+	return res, nil
+}
+
+// check returns why op is not allowed by rules, or "" if it is.
+func check(rules []compiledRule, op *Operation) string {
+	locs := []Pointer{op.Path}
+	if op.Op == "move" || op.Op == "copy" {
+		locs = append(locs, op.From)
+	}
+	for _, loc := range locs {
+		allowed := false
+		for i := range rules {
+			if !rules[i].covers(op.Op, loc) {
+				continue
+			}
+			if rules[i].Deny {
+				return fmt.Sprintf("%v is denied by the rule for %v", loc.String(), rules[i].Prefix)
+			}
+			allowed = true
+		}
+		if !allowed {
+			return fmt.Sprintf("no rule allows %v on %v", op.Op, loc.String())
+		}
+	}
+	return ""
+}
+
+// Check returns every operation in patch that the policy does not
+// allow.  It returns an error if one of the rules is invalid.
+func (p Policy) Check(patch Patch) ([]Violation, error) {
+	rules, err := p.compile()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]Violation, 0)
+	for i := range patch {
+		if reason := check(rules, &patch[i]); reason != "" {
+			res = append(res, Violation{i, patch[i], reason})
+		}
+	}
+	return res, nil
+}
+
+// CheckJSON does the same thing as Check, except that rawPatch must
+// be a []byte containing a valid JSON Patch.
+func (p Policy) CheckJSON(rawPatch []byte) ([]Violation, error) {
+	patch, err := NewPatch(rawPatch)
+	if err != nil {
+		return nil, err
+	}
+	return p.Check(patch)
+}
+
+// BeforeOp can be used as ApplyOptions.BeforeOp to refuse to apply
+// operations the policy does not allow.
+func (p Policy) BeforeOp(op *Operation, doc interface{}) error {
+	rules, err := p.compile()
+	if err != nil {
+		return err
+	}
+	if reason := check(rules, op); reason != "" {
+		return fmt.Errorf("%v %v is not allowed: %v", op.Op, op.Path.String(), reason)
+	}
+	return nil
+}
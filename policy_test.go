@@ -0,0 +1,63 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var testPolicy = Policy{Rules: []Rule{
+	{Prefix: "/spec", Ops: []string{"replace", "test"}},
+	{Prefix: "/spec/items/*/name", Ops: []string{"add", "remove"}},
+	{Prefix: "/metadata/labels"},
+	{Prefix: "/metadata/owner", Deny: true},
+	{Prefix: "/spec/secret", Deny: true},
+}}
+
+type policyTest struct {
+	patch      string
+	violations []int
+}
+
+var policyTests = []policyTest{
+	{`[{"op":"replace","path":"/spec/a","value":1},{"op":"test","path":"/spec/b","value":1}]`, []int{}},
+	{`[{"op":"add","path":"/spec/a","value":1},{"op":"remove","path":"/status"}]`, []int{0, 1}},
+	{`[{"op":"add","path":"/spec/items/3/name","value":"x"},{"op":"remove","path":"/spec/items/0/name"}]`, []int{}},
+	{`[{"op":"add","path":"/spec/items/3/id","value":"x"}]`, []int{0}},
+	{`[{"op":"add","path":"/metadata/labels/a","value":"x"},{"op":"remove","path":"/metadata/labels"}]`, []int{}},
+	{`[{"op":"replace","path":"/metadata/owner","value":"x"}]`, []int{0}},
+	{`[{"op":"replace","path":"/spec/secret/key","value":"x"}]`, []int{0}},
+	{`[{"op":"replace","path":"/spec","value":{}}]`, []int{0}},
+	{`[{"op":"move","from":"/spec/secret","path":"/metadata/labels/x"}]`, []int{0}},
+	{`[{"op":"copy","from":"/metadata/labels/a","path":"/metadata/labels/b"}]`, []int{}},
+}
+
+func TestPolicy(t *testing.T) {
+	for _, test := range policyTests {
+		violations, err := testPolicy.CheckJSON([]byte(test.patch))
+		if err != nil {
+			t.Errorf("Failed to check `%v` (%v)", test.patch, err)
+			continue
+		}
+		found := make([]int, len(violations))
+		for i := range violations {
+			found[i] = violations[i].Index
+		}
+		if !reflect.DeepEqual(found, test.violations) {
+			t.Errorf("`%v` had violations %v, not %v: %v", test.patch, found, test.violations, violations)
+		}
+	}
+	if _, err := (Policy{Rules: []Rule{{Prefix: "nope"}}}).CheckJSON([]byte(`[]`)); err == nil {
+		t.Errorf("Expected a policy with an invalid prefix to fail")
+	}
+}
+
+func TestPolicyBeforeOp(t *testing.T) {
+	var src interface{}
+	json.Unmarshal([]byte(`{"spec":{"a":1},"metadata":{"owner":"me"}}`), &src)
+	p := `[{"op":"replace","path":"/spec/a","value":2},{"op":"replace","path":"/metadata/owner","value":"you"}]`
+	_, err, idx := ApplyWithOptions(src, []byte(p), ApplyOptions{BeforeOp: testPolicy.BeforeOp})
+	if err == nil || idx != 1 {
+		t.Errorf("Expected the policy to refuse operation 1, got %v at %v", err, idx)
+	}
+}
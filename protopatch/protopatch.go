@@ -0,0 +1,257 @@
+// Package protopatch applies JSON patches to protobuf Values, such as
+// the fields of a google.protobuf.Struct, and generates patches from
+// pairs of them.  Patches are applied to a clone of the Value directly,
+// without a round trip through encoding/json.
+package protopatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/VictorLowther/jsonpatch"
+	"github.com/VictorLowther/jsonpatch/internal/docutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// pbGet returns the value p points at in from.
+func pbGet(p jsonpatch.Pointer, from *structpb.Value) (*structpb.Value, error) {
+	for i, seg := range p {
+		selector := string(seg)
+		switch t := from.GetKind().(type) {
+		case *structpb.Value_StructValue:
+			found, ok := t.StructValue.GetFields()[selector]
+			if !ok {
+				return nil, fmt.Errorf("Selector %v not a member of %v", selector, p[:i].String())
+			}
+			from = found
+		case *structpb.Value_ListValue:
+			index, err := docutil.NormalizeOffset(selector, len(t.ListValue.GetValues()))
+			if err != nil {
+				return nil, err
+			}
+			from = t.ListValue.Values[index]
+		default:
+			return nil, fmt.Errorf("Cannot index pointer %v for non-indexable JSON value", p.String())
+		}
+	}
+	return from, nil
+}
+
+// pbContainer returns the value that holds the value p points at,
+// along with the last selector of p.
+func pbContainer(p jsonpatch.Pointer, from *structpb.Value) (string, *structpb.Value, error) {
+	selector, parent := p.Chop()
+	container, err := pbGet(parent, from)
+	return selector, container, err
+}
+
+// pbReplace replaces the value p points at in to, which must exist.
+func pbReplace(p jsonpatch.Pointer, to, val *structpb.Value) (*structpb.Value, error) {
+	if len(p) == 0 {
+		return val, nil
+	}
+	selector, container, err := pbContainer(p, to)
+	if err != nil {
+		return to, err
+	}
+	switch t := container.GetKind().(type) {
+	case *structpb.Value_StructValue:
+		if _, ok := t.StructValue.GetFields()[selector]; !ok {
+			return to, fmt.Errorf("%v does not refer to an existing location", p.String())
+		}
+		t.StructValue.Fields[selector] = val
+	case *structpb.Value_ListValue:
+		index, err := docutil.NormalizeOffset(selector, len(t.ListValue.GetValues()))
+		if err != nil {
+			return to, err
+		}
+		t.ListValue.Values[index] = val
+	default:
+		return to, fmt.Errorf("Cannot put to non-indexable JSON value")
+	}
+	return to, nil
+}
+
+// pbPut adds val to to at p, as per the add operation.
+func pbPut(p jsonpatch.Pointer, to, val *structpb.Value) (*structpb.Value, error) {
+	if len(p) == 0 {
+		return val, nil
+	}
+	selector, container, err := pbContainer(p, to)
+	if err != nil {
+		return to, err
+	}
+	switch t := container.GetKind().(type) {
+	case *structpb.Value_StructValue:
+		if t.StructValue.Fields == nil {
+			t.StructValue.Fields = map[string]*structpb.Value{}
+		}
+		t.StructValue.Fields[selector] = val
+	case *structpb.Value_ListValue:
+		vals := t.ListValue.GetValues()
+		if selector == "-" || selector == strconv.Itoa(len(vals)) {
+			t.ListValue.Values = append(vals, val)
+			return to, nil
+		}
+		index, err := docutil.NormalizeOffset(selector, len(vals))
+		if err != nil {
+			return to, err
+		}
+		res := make([]*structpb.Value, 0, len(vals)+1)
+		res = append(res, vals[:index]...)
+		res = append(res, val)
+		t.ListValue.Values = append(res, vals[index:]...)
+	default:
+		return to, fmt.Errorf("Cannot put to non-indexable JSON value")
+	}
+	return to, nil
+}
+
+// pbRemove removes the value p points at from from.
+func pbRemove(p jsonpatch.Pointer, from *structpb.Value) (*structpb.Value, error) {
+	if len(p) == 0 {
+		return from, fmt.Errorf("Cannot remove the whole document")
+	}
+	selector, container, err := pbContainer(p, from)
+	if err != nil {
+		return from, err
+	}
+	switch t := container.GetKind().(type) {
+	case *structpb.Value_StructValue:
+		if _, ok := t.StructValue.GetFields()[selector]; !ok {
+			return from, fmt.Errorf("`%v` does not point to an existing location", p.String())
+		}
+		delete(t.StructValue.Fields, selector)
+	case *structpb.Value_ListValue:
+		vals := t.ListValue.GetValues()
+		index, err := docutil.NormalizeOffset(selector, len(vals))
+		if err != nil {
+			return from, err
+		}
+		t.ListValue.Values = append(vals[:index], vals[index+1:]...)
+	default:
+		return from, fmt.Errorf("Cannot remove non-indexable JSON value")
+	}
+	return from, nil
+}
+
+// applyOp performs a single operation on to.
+func applyOp(o *jsonpatch.Operation, to *structpb.Value) (*structpb.Value, error) {
+	var val *structpb.Value
+	switch o.Op {
+	case "test", "replace", "add":
+		var err error
+		if val, err = structpb.NewValue(o.Value); err != nil {
+			return to, err
+		}
+	}
+	switch o.Op {
+	case "test":
+		found, err := pbGet(o.Path, to)
+		if err == nil && !proto.Equal(found, val) {
+			err = fmt.Errorf("Test op failed.")
+		}
+		return to, err
+	case "replace":
+		return pbReplace(o.Path, to, val)
+	case "add":
+		return pbPut(o.Path, to, val)
+	case "remove":
+		return pbRemove(o.Path, to)
+	case "move":
+		if len(o.Path) > len(o.From) && o.Path[:len(o.From)].Equal(o.From) {
+			return to, fmt.Errorf("Cannot move %v into one of its children", o.From.String())
+		}
+		found, err := pbGet(o.From, to)
+		if err != nil {
+			return to, err
+		}
+		if to, err = pbRemove(o.From, to); err != nil {
+			return to, err
+		}
+		return pbPut(o.Path, to, found)
+	case "copy":
+		found, err := pbGet(o.From, to)
+		if err != nil {
+			return to, err
+		}
+		return pbPut(o.Path, to, proto.Clone(found).(*structpb.Value))
+	default:
+		return to, fmt.Errorf("Invalid op %v", o.Op)
+	}
+}
+
+// ApplyPatch applies every operation in p to a copy of base.  If err
+// is returned, loc is the index of the operation that failed.  base is
+// not modified.
+func ApplyPatch(p jsonpatch.Patch, base *structpb.Value) (result *structpb.Value, err error, loc int) {
+	result = proto.Clone(base).(*structpb.Value)
+	for i := range p {
+		if result, err = applyOp(&p[i], result); err != nil {
+			return result, err, i
+		}
+	}
+	return result, nil, 0
+}
+
+// Apply does the same thing as jsonpatch.Apply, except the document is
+// a protobuf Value.  Use structpb.NewStructValue to patch a Struct.
+func Apply(base *structpb.Value, rawPatch []byte) (result *structpb.Value, err error, loc int) {
+	p, err := jsonpatch.NewPatch(rawPatch)
+	if err != nil {
+		return nil, err, 0
+	}
+	return ApplyPatch(p, base)
+}
+
+// pbKind returns a name for the kind of value v holds.
+func pbKind(v *structpb.Value) string {
+	return fmt.Sprintf("%T", v.GetKind())
+}
+
+// gen diffs two protobuf Values the same way jsonpatch.Generate diffs
+// unmarshalled JSON.
+func gen(base, target *structpb.Value, ptr jsonpatch.Pointer, paranoid bool) jsonpatch.Patch {
+	res := make(jsonpatch.Patch, 0)
+	if proto.Equal(base, target) {
+		return res
+	}
+	baseStruct, ok := base.GetKind().(*structpb.Value_StructValue)
+	if !ok || pbKind(base) != pbKind(target) {
+		if paranoid {
+			res = append(res, jsonpatch.Operation{Op: "test", Path: ptr, Value: base.AsInterface()})
+		}
+		return append(res, jsonpatch.Operation{Op: "replace", Path: ptr, Value: target.AsInterface()})
+	}
+	baseVal := baseStruct.StructValue.GetFields()
+	targetVal := target.GetStructValue().GetFields()
+	for _, k := range docutil.SortedKeys(baseVal) {
+		if _, ok := targetVal[k]; ok {
+			continue
+		}
+		newPtr := ptr.Append(k)
+		if paranoid {
+			res = append(res, jsonpatch.Operation{Op: "test", Path: newPtr, Value: baseVal[k].AsInterface()})
+		}
+		res = append(res, jsonpatch.Operation{Op: "remove", Path: newPtr})
+	}
+	for _, k := range docutil.SortedKeys(baseVal) {
+		if newVal, ok := targetVal[k]; ok {
+			res = append(res, gen(baseVal[k], newVal, ptr.Append(k), paranoid)...)
+		}
+	}
+	for _, k := range docutil.SortedKeys(targetVal) {
+		if _, ok := baseVal[k]; !ok {
+			res = append(res, jsonpatch.Operation{Op: "add", Path: ptr.Append(k), Value: targetVal[k].AsInterface()})
+		}
+	}
+	return res
+}
+
+// Generate does the same thing as jsonpatch.Generate, except base and
+// target are protobuf Values.
+func Generate(base, target *structpb.Value, paranoid bool) ([]byte, error) {
+	return json.Marshal(gen(base, target, make(jsonpatch.Pointer, 0), paranoid))
+}
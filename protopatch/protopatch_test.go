@@ -0,0 +1,111 @@
+package protopatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/VictorLowther/jsonpatch"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func mustProto(t *testing.T, s string) *structpb.Value {
+	var val interface{}
+	if err := json.Unmarshal([]byte(s), &val); err != nil {
+		t.Fatalf("Bad test JSON %v: %v", s, err)
+	}
+	res, err := structpb.NewValue(val)
+	if err != nil {
+		t.Fatalf("Cannot convert %v: %v", s, err)
+	}
+	return res
+}
+
+// applyTests are checked against what jsonpatch.Apply does with the
+// same document and patch.
+var applyTests = []struct {
+	src, patch string
+}{
+	{`{"foo":5}`, `[{"op":"test","path":"/foo","value":5}]`},
+	{`{"foo":5}`, `[{"op":"test","path":"/foo","value":"5"}]`},
+	{`{"foo":[1,{"a":null}]}`, `[{"op":"test","path":"/foo","value":[1,{"a":null}]}]`},
+	{`{"foo":1}`, `[{"op":"add","path":"/bar","value":{"x":[true]}}]`},
+	{`{"foo":[1,3]}`, `[{"op":"add","path":"/foo/1","value":2},{"op":"add","path":"/foo/-","value":4}]`},
+	{`{"foo":[1]}`, `[{"op":"add","path":"/foo/1","value":2},{"op":"add","path":"/foo/5","value":3}]`},
+	{`{"foo":1}`, `[{"op":"add","path":"","value":[1,2]}]`},
+	{`{"foo":1,"bar":2}`, `[{"op":"remove","path":"/foo"},{"op":"remove","path":"/foo"}]`},
+	{`{"foo":[1,2,3]}`, `[{"op":"remove","path":"/foo/-1"},{"op":"remove","path":"/foo/0"}]`},
+	{`{"foo":1}`, `[{"op":"remove","path":""}]`},
+	{`{"foo":{"a":1}}`, `[{"op":"replace","path":"/foo/a","value":"x"},{"op":"replace","path":"/foo/b","value":2}]`},
+	{`{"foo":[1,2]}`, `[{"op":"replace","path":"/foo/1","value":{"y":1}}]`},
+	{`{"foo":{"a":1},"bar":{}}`, `[{"op":"move","from":"/foo/a","path":"/bar/a"},{"op":"move","from":"/bar","path":"/bar/c"}]`},
+	{`{"foo":[1,2,3]}`, `[{"op":"move","from":"/foo/0","path":"/foo/-"}]`},
+	{`{"foo":{"a":[1]}}`, `[{"op":"copy","from":"/foo","path":"/bar"},{"op":"add","path":"/bar/a/-","value":2}]`},
+	{`{"foo":1}`, `[{"op":"copy","from":"/nope","path":"/bar"}]`},
+	{`{"foo":"bar"}`, `[{"op":"add","path":"/foo/0","value":1}]`},
+}
+
+func TestApply(t *testing.T) {
+	for _, test := range applyTests {
+		base := mustProto(t, test.src)
+		res, err, loc := Apply(base, []byte(test.patch))
+		var src interface{}
+		json.Unmarshal([]byte(test.src), &src)
+		expected, expectedErr, expectedLoc := jsonpatch.Apply(src, []byte(test.patch))
+		if expectedErr != nil {
+			if err == nil {
+				t.Errorf("Applying %v to %v: expected failure, got %v", test.patch, test.src, res.AsInterface())
+			} else if loc != expectedLoc {
+				t.Errorf("Applying %v to %v: expected failure at %d, got %d", test.patch, test.src, expectedLoc, loc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Applying %v to %v: failed (%v)", test.patch, test.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(res.AsInterface(), expected) {
+			t.Errorf("Applying %v to %v: expected %v, got %v", test.patch, test.src, expected, res.AsInterface())
+		}
+		if !proto.Equal(base, mustProto(t, test.src)) {
+			t.Errorf("Applying %v to %v modified the base document", test.patch, test.src)
+		}
+	}
+}
+
+var genTests = []struct {
+	base, target string
+}{
+	{`{"d":1,"c":2,"b":3,"a":4}`, `{"a":5,"b":3,"e":6,"f":7}`},
+	{`{"z":{"b":1,"a":1},"y":{"b":1,"a":1}}`, `{"z":{"b":2,"a":2},"y":{"b":2,"a":2}}`},
+	{`{"a/b":1,"c~d":1}`, `{"a/b":2}`},
+	{`{"a":[1,2],"b":{"c":"x"}}`, `{"a":[1,2,3],"b":{"c":false}}`},
+	{`{"a":1}`, `[1]`},
+	{`{"a":1}`, `{"a":1}`},
+}
+
+func TestGenerate(t *testing.T) {
+	for _, test := range genTests {
+		for _, paranoid := range []bool{false, true} {
+			base, target := mustProto(t, test.base), mustProto(t, test.target)
+			p, err := Generate(base, target, paranoid)
+			if err != nil {
+				t.Errorf("Failed to generate patch for %v: %v", test.target, err)
+				continue
+			}
+			expected, _ := jsonpatch.GenerateJSON([]byte(test.base), []byte(test.target), paranoid)
+			if string(p) != string(expected) {
+				t.Errorf("Generated patch %v, expected %v", string(p), string(expected))
+			}
+			res, err, _ := Apply(base, p)
+			if err != nil {
+				t.Errorf("Failed to apply generated patch %v: %v", string(p), err)
+				continue
+			}
+			if !reflect.DeepEqual(res.AsInterface(), target.AsInterface()) {
+				t.Errorf("Patch %v turned %v into %v, not %v", string(p), test.base, res.AsInterface(), test.target)
+			}
+		}
+	}
+}
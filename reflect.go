@@ -0,0 +1,417 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// Pointers can also address documents built out of ordinary Go
+// values instead of unmarshalled JSON.  Maps with string or integer
+// keys, slices, arrays, and structs are all containers, and struct
+// fields are named the same way encoding/json names them.  Values put
+// into typed containers are converted to the right type by
+// marshalling them to JSON and back if they are not already of it.
+
+// indirect follows pointers and interfaces until it finds a value.
+func indirect(v reflect.Value) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return v, fmt.Errorf("Cannot index nil %v", v.Type())
+		}
+		v = v.Elem()
+	}
+	return v, nil
+}
+
+// jsonName returns the name encoding/json would give field, and
+// whether that name came from its tag.  The name is "" if
+// encoding/json would leave the field out.
+func jsonName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, false
+}
+
+// jsonField is a field of a struct, possibly promoted from an
+// embedded struct, as encoding/json sees it.
+type jsonField struct {
+	index  []int
+	tagged bool
+}
+
+// jsonFields returns the fields encoding/json would use for t, keyed by
+// name.  As with encoding/json, a field promoted from an embedded
+// struct is hidden by one with the same name at a shallower depth,
+// a tagged field beats an untagged one at the same depth, and names
+// that are still ambiguous are left out.
+func jsonFields(t reflect.Type) map[string]jsonField {
+	res := map[string]jsonField{}
+	visited := map[reflect.Type]bool{}
+	// next holds the indexes of the embedded structs at the next
+	// depth down, starting with t itself.
+	next := [][]int{nil}
+	for len(next) > 0 {
+		level := next
+		next = nil
+		found := map[string][]jsonField{}
+		seen := map[reflect.Type]bool{}
+		for _, at := range level {
+			st := t
+			if len(at) > 0 {
+				st = t.FieldByIndex(at).Type
+				if st.Kind() == reflect.Ptr {
+					st = st.Elem()
+				}
+			}
+			// A struct embedded twice at the same depth makes its
+			// fields ambiguous, so only skip ones seen higher up.
+			if visited[st] {
+				continue
+			}
+			seen[st] = true
+			for i := 0; i < st.NumField(); i++ {
+				field := st.Field(i)
+				ft := field.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if field.PkgPath != "" && !(field.Anonymous && ft.Kind() == reflect.Struct) {
+					continue
+				}
+				name, tagged := jsonName(field)
+				if name == "" {
+					continue
+				}
+				index := append(append([]int{}, at...), i)
+				if field.Anonymous && !tagged && ft.Kind() == reflect.Struct {
+					next = append(next, index)
+					continue
+				}
+				if field.PkgPath != "" {
+					continue
+				}
+				found[name] = append(found[name], jsonField{index, tagged})
+			}
+		}
+		for name, fields := range found {
+			if _, ok := res[name]; ok {
+				continue
+			}
+			var winner *jsonField
+			ambiguous := false
+			for i := range fields {
+				switch {
+				case winner == nil || (fields[i].tagged && !winner.tagged):
+					winner, ambiguous = &fields[i], false
+				case fields[i].tagged == winner.tagged:
+					ambiguous = true
+				}
+			}
+			if ambiguous {
+				// Hide the name from deeper fields as well.
+				res[name] = jsonField{}
+				continue
+			}
+			res[name] = *winner
+		}
+		for st := range seen {
+			visited[st] = true
+		}
+	}
+	for name, field := range res {
+		if field.index == nil {
+			delete(res, name)
+		}
+	}
+	return res
+}
+
+// structField finds the field of the struct v named name, looking
+// inside embedded structs the way encoding/json does.
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	field, ok := jsonFields(v.Type())[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	for i, idx := range field.index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(idx)
+	}
+	return v, true
+}
+
+// mapKey turns selector into a key for the map v.
+func mapKey(v reflect.Value, selector string) (reflect.Value, error) {
+	kt := v.Type().Key()
+	switch kt.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(selector).Convert(kt), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(selector, 10, kt.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n).Convert(kt), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(selector, 10, kt.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(n).Convert(kt), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("Cannot index map with %v keys", kt)
+	}
+}
+
+// reflectChild returns the member of v named by selector.
+func reflectChild(v reflect.Value, selector string) (reflect.Value, error) {
+	v, err := indirect(v)
+	if err != nil {
+		return v, err
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		key, err := mapKey(v, selector)
+		if err != nil {
+			return v, err
+		}
+		res := v.MapIndex(key)
+		if !res.IsValid() {
+			return res, fmt.Errorf("Selector %v not a member of %v", selector, v.Type())
+		}
+		return res, nil
+	case reflect.Slice, reflect.Array:
+		index, err := normalizeOffset(selector, v.Len())
+		if err != nil {
+			return v, err
+		}
+		return v.Index(index), nil
+	case reflect.Struct:
+		res, ok := structField(v, selector)
+		if !ok {
+			return res, fmt.Errorf("Selector %v not a field of %v", selector, v.Type())
+		}
+		return res, nil
+	default:
+		return v, fmt.Errorf("Cannot index non-indexable value of type %v", v.Type())
+	}
+}
+
+// convertTo turns val into something that can be stored in a t.
+func convertTo(val interface{}, t reflect.Type) (reflect.Value, error) {
+	if val == nil {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("Cannot store null in a %v", t)
+	}
+	v := reflect.ValueOf(val)
+	if v.Type().AssignableTo(t) {
+		return v, nil
+	}
+	buf, err := json.Marshal(val)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	res := reflect.New(t)
+	if err := json.Unmarshal(buf, res.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("Cannot store %v in a %v: %v", string(buf), t, err)
+	}
+	return res.Elem(), nil
+}
+
+// settable returns v if it can be changed in place, or a copy of it
+// that can.
+func settable(v reflect.Value) reflect.Value {
+	if v.CanSet() {
+		return v
+	}
+	res := reflect.New(v.Type()).Elem()
+	res.Set(v)
+	return res
+}
+
+// reflectStore stores val as the member of container named by
+// selector, which must already exist unless container is a map.  It
+// returns the changed container, which may be a copy.
+func reflectStore(container reflect.Value, selector string, val interface{}) (reflect.Value, error) {
+	switch container.Kind() {
+	case reflect.Map:
+		key, err := mapKey(container, selector)
+		if err != nil {
+			return container, err
+		}
+		elem, err := convertTo(val, container.Type().Elem())
+		if err != nil {
+			return container, err
+		}
+		if container.IsNil() {
+			container = reflect.MakeMap(container.Type())
+		}
+		container.SetMapIndex(key, elem)
+		return container, nil
+	case reflect.Slice, reflect.Array:
+		index, err := normalizeOffset(selector, container.Len())
+		if err != nil {
+			return container, err
+		}
+		elem, err := convertTo(val, container.Type().Elem())
+		if err != nil {
+			return container, err
+		}
+		if container.Kind() == reflect.Array {
+			container = settable(container)
+		}
+		container.Index(index).Set(elem)
+		return container, nil
+	case reflect.Struct:
+		container = settable(container)
+		field, ok := structField(container, selector)
+		if !ok {
+			return container, fmt.Errorf("Selector %v not a field of %v", selector, container.Type())
+		}
+		elem, err := convertTo(val, field.Type())
+		if err != nil {
+			return container, err
+		}
+		field.Set(elem)
+		return container, nil
+	default:
+		return container, fmt.Errorf("Cannot put to non-indexable value of type %v", container.Type())
+	}
+}
+
+// containerFunc changes the member of container named by selector,
+// and returns the changed container, which may be a copy.
+type containerFunc func(container reflect.Value, selector string) (reflect.Value, error)
+
+// reflectUpdate calls fn on the container that holds the value p
+// points at in v, and then stores the changed container back into
+// its parents all the way up.  It returns the new value for v.
+func reflectUpdate(v reflect.Value, p Pointer, fn containerFunc) (reflect.Value, error) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v, fmt.Errorf("Cannot index nil %v", v.Type())
+		}
+		return reflectUpdate(v.Elem(), p, fn)
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v, fmt.Errorf("Cannot index nil %v", v.Type())
+		}
+		if obj, ok := v.Interface().(*utils.Object); ok {
+			selector, rest := p.Shift()
+			child, ok := obj.Get(selector)
+			if !ok || len(rest) == 0 {
+				return v, fmt.Errorf("Selector %v not a member of %v", selector, p.String())
+			}
+			res, err := reflectUpdate(reflect.ValueOf(child), rest, fn)
+			if err != nil {
+				return v, err
+			}
+			obj.Set(selector, res.Interface())
+			return v, nil
+		}
+		res, err := reflectUpdate(v.Elem(), p, fn)
+		if err != nil {
+			return v, err
+		}
+		v.Elem().Set(res)
+		return v, nil
+	}
+	selector, rest := p.Shift()
+	if len(rest) == 0 {
+		return fn(v, selector)
+	}
+	child, err := reflectChild(v, selector)
+	if err != nil {
+		return v, err
+	}
+	res, err := reflectUpdate(child, rest, fn)
+	if err != nil {
+		return v, err
+	}
+	return reflectStore(v, selector, res.Interface())
+}
+
+// reflectReplace replaces the existing member of container named by
+// selector with val.
+func reflectReplace(val interface{}) containerFunc {
+	return func(container reflect.Value, selector string) (reflect.Value, error) {
+		if _, err := reflectChild(container, selector); err != nil {
+			return container, err
+		}
+		return reflectStore(container, selector, val)
+	}
+}
+
+// reflectPut adds val to container as per the add operation.
+func reflectPut(val interface{}) containerFunc {
+	return func(container reflect.Value, selector string) (reflect.Value, error) {
+		switch container.Kind() {
+		case reflect.Slice:
+			elem, err := convertTo(val, container.Type().Elem())
+			if err != nil {
+				return container, err
+			}
+			n := container.Len()
+			index := n
+			if selector != "-" && selector != strconv.Itoa(n) {
+				if index, err = normalizeOffset(selector, n); err != nil {
+					return container, err
+				}
+			}
+			res := reflect.MakeSlice(container.Type(), 0, n+1)
+			res = reflect.AppendSlice(res, container.Slice(0, index))
+			res = reflect.Append(res, elem)
+			return reflect.AppendSlice(res, container.Slice(index, n)), nil
+		case reflect.Array:
+			return container, fmt.Errorf("Cannot add to fixed-size %v", container.Type())
+		default:
+			return reflectStore(container, selector, val)
+		}
+	}
+}
+
+// reflectRemove removes the member of container named by selector.
+// Struct fields cannot be removed, so they are set to their zero value.
+func reflectRemove(container reflect.Value, selector string) (reflect.Value, error) {
+	if _, err := reflectChild(container, selector); err != nil {
+		return container, err
+	}
+	switch container.Kind() {
+	case reflect.Map:
+		key, _ := mapKey(container, selector)
+		container.SetMapIndex(key, reflect.Value{})
+		return container, nil
+	case reflect.Slice:
+		n := container.Len()
+		index, _ := normalizeOffset(selector, n)
+		res := reflect.MakeSlice(container.Type(), 0, n-1)
+		res = reflect.AppendSlice(res, container.Slice(0, index))
+		return reflect.AppendSlice(res, container.Slice(index+1, n)), nil
+	case reflect.Struct:
+		container = settable(container)
+		field, _ := structField(container, selector)
+		field.Set(reflect.Zero(field.Type()))
+		return container, nil
+	default:
+		return container, fmt.Errorf("Cannot remove from %v", container.Type())
+	}
+}
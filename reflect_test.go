@@ -0,0 +1,136 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type reflectBase struct {
+	ID string `json:"id"`
+	// Name is hidden by reflectDoc.Name, and Kind is ambiguous
+	// because reflectOther has one at the same depth.
+	Name string `json:"name"`
+	Kind string
+}
+
+type reflectOther struct {
+	Kind string
+}
+
+type reflectInner struct {
+	Enabled bool
+	Weights map[int]float32 `json:"weights"`
+}
+
+type reflectDoc struct {
+	reflectBase
+	reflectOther
+	Name   string                  `json:"name"`
+	Count  int                     `json:"count,omitempty"`
+	Tags   []string                `json:"tags"`
+	Ports  [2]int                  `json:"ports"`
+	Inner  *reflectInner           `json:"inner"`
+	ByName map[string]reflectInner `json:"byName"`
+	Extra  interface{}             `json:"extra"`
+	Skip   string                  `json:"-"`
+	hidden int
+}
+
+func newReflectDoc() reflectDoc {
+	return reflectDoc{
+		reflectBase:  reflectBase{ID: "x", Name: "base", Kind: "a"},
+		reflectOther: reflectOther{Kind: "b"},
+		Name:         "thing",
+		Count:        2,
+		Tags:         []string{"a", "b"},
+		Ports:        [2]int{80, 443},
+		Inner:        &reflectInner{Weights: map[int]float32{1: 0.5}},
+		ByName:       map[string]reflectInner{"one": {Enabled: true}},
+		Extra:        map[string]interface{}{"list": []interface{}{1.0}},
+		Skip:         "skip",
+		hidden:       1,
+	}
+}
+
+func TestReflectGet(t *testing.T) {
+	doc := newReflectDoc()
+	tests := map[string]interface{}{
+		"/id":                  "x",
+		"/name":                "thing",
+		"/tags/1":              "b",
+		"/ports/0":             80,
+		"/inner/Enabled":       false,
+		"/inner/weights/1":     float32(0.5),
+		"/byName/one/Enabled":  true,
+		"/extra/list/0":        1.0,
+		"/tags/-1":             "b",
+		"/Skip":                nil,
+		"/hidden":              nil,
+		"/Kind":                nil,
+		"/inner/weights/2":     nil,
+		"/byName/one/Enabled/": nil,
+	}
+	for path, expected := range tests {
+		for _, from := range []interface{}{doc, &doc} {
+			ptr, _ := NewPointer(path)
+			val, err := ptr.Get(from)
+			if expected == nil {
+				if err == nil {
+					t.Errorf("Expected getting %v to fail, got %v", path, val)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Failed to get %v: %v", path, err)
+			} else if !reflect.DeepEqual(val, expected) {
+				t.Errorf("Getting %v: expected %#v, got %#v", path, expected, val)
+			}
+		}
+	}
+}
+
+func TestReflectApply(t *testing.T) {
+	tests := []struct {
+		patch    string
+		expected string
+	}{
+		{`[{"op":"replace","path":"/name","value":"other"},{"op":"replace","path":"/id","value":"y"}]`,
+			`{"id":"y","name":"other","count":2,"tags":["a","b"],"ports":[80,443],"inner":{"Enabled":false,"weights":{"1":0.5}},"byName":{"one":{"Enabled":true,"weights":null}},"extra":{"list":[1]}}`},
+		{`[{"op":"add","path":"/tags/1","value":"c"},{"op":"remove","path":"/tags/0"},{"op":"add","path":"/tags/-","value":"d"}]`,
+			`{"id":"x","name":"thing","count":2,"tags":["c","b","d"],"ports":[80,443],"inner":{"Enabled":false,"weights":{"1":0.5}},"byName":{"one":{"Enabled":true,"weights":null}},"extra":{"list":[1]}}`},
+		{`[{"op":"replace","path":"/ports/1","value":8443},{"op":"remove","path":"/count"},{"op":"add","path":"/inner/weights/2","value":1.5}]`,
+			`{"id":"x","name":"thing","tags":["a","b"],"ports":[80,8443],"inner":{"Enabled":false,"weights":{"1":0.5,"2":1.5}},"byName":{"one":{"Enabled":true,"weights":null}},"extra":{"list":[1]}}`},
+		{`[{"op":"replace","path":"/byName/one/Enabled","value":false},{"op":"add","path":"/byName/two","value":{"Enabled":true}},{"op":"add","path":"/extra/list/0","value":0}]`,
+			`{"id":"x","name":"thing","count":2,"tags":["a","b"],"ports":[80,443],"inner":{"Enabled":false,"weights":{"1":0.5}},"byName":{"one":{"Enabled":false,"weights":null},"two":{"Enabled":true,"weights":null}},"extra":{"list":[0,1]}}`},
+		{`[{"op":"copy","from":"/tags","path":"/extra/tags"},{"op":"move","from":"/byName/one","path":"/byName/uno"},{"op":"test","path":"/name","value":"thing"}]`,
+			`{"id":"x","name":"thing","count":2,"tags":["a","b"],"ports":[80,443],"inner":{"Enabled":false,"weights":{"1":0.5}},"byName":{"uno":{"Enabled":true,"weights":null}},"extra":{"list":[1],"tags":["a","b"]}}`},
+		{`[{"op":"add","path":"/ports/-","value":1}]`, ``},
+		{`[{"op":"add","path":"/nope","value":1}]`, ``},
+		{`[{"op":"replace","path":"/count","value":"many"}]`, ``},
+		{`[{"op":"remove","path":"/tags/5"}]`, ``},
+	}
+	for _, test := range tests {
+		base := newReflectDoc()
+		for _, doc := range []interface{}{base, &base} {
+			res, err, _ := Apply(doc, []byte(test.patch))
+			if test.expected == "" {
+				if err == nil {
+					t.Errorf("Expected %v to fail", test.patch)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Failed to apply %v: %v", test.patch, err)
+				continue
+			}
+			buf, _ := json.Marshal(res)
+			if string(buf) != test.expected {
+				t.Errorf("Applying %v: expected\n%v\ngot\n%v", test.patch, test.expected, string(buf))
+			}
+			if !reflect.DeepEqual(base, newReflectDoc()) {
+				t.Errorf("Applying %v modified the base document", test.patch)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package jsonpatch
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RelativePointer is a Relative JSON Pointer, as defined in
+// draft-handrews-relative-json-pointer.  It refers to a location
+// relative to some other location, such as `1/name` for the name
+// member of the object containing the current value, or `0#` for the
+// name or index of the current value itself.
+type RelativePointer struct {
+	// Up is how many levels to go up from the base location.
+	Up int
+	// Offset is added to the array index of the location reached
+	// after going up, to refer to one of its siblings.
+	Offset int
+	// Key is true if the relative pointer ends in `#`, which means it
+	// refers to the member name or array index of the location, not
+	// its value.
+	Key bool
+	// Pointer is followed from the location reached after going up.
+	// It is always empty if Key is true.
+	Pointer Pointer
+}
+
+// NewRelativePointer parses a Relative JSON Pointer.
+func NewRelativePointer(s string) (RelativePointer, error) {
+	res := RelativePointer{}
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end == -1 {
+		end = len(s)
+	}
+	if end == 0 || (end > 1 && s[0] == '0') {
+		return res, fmt.Errorf("`%v` must start with a non-negative integer without leading zeros", s)
+	}
+	up, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return res, err
+	}
+	res.Up = up
+	s = s[end:]
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		end = strings.IndexFunc(s[1:], func(r rune) bool { return r < '0' || r > '9' })
+		if end == -1 {
+			end = len(s) - 1
+		}
+		digits := s[1 : end+1]
+		if digits == "" || (len(digits) > 1 && digits[0] == '0') {
+			return res, fmt.Errorf("Index offset `%v` must be an integer without leading zeros", s)
+		}
+		offset, err := strconv.Atoi(s[:end+1])
+		if err != nil {
+			return res, err
+		}
+		res.Offset = offset
+		s = s[end+1:]
+	}
+	if s == "#" {
+		res.Key = true
+		res.Pointer = make(Pointer, 0)
+		return res, nil
+	}
+	res.Pointer, err = NewPointer(s)
+	return res, err
+}
+
+// String returns the string form of the relative pointer.
+func (r RelativePointer) String() string {
+	res := strconv.Itoa(r.Up)
+	if r.Offset > 0 {
+		res += "+"
+	}
+	if r.Offset != 0 {
+		res += strconv.Itoa(r.Offset)
+	}
+	if r.Key {
+		return res + "#"
+	}
+	return res + r.Pointer.String()
+}
+
+// origin finds the location r starts following its pointer from.
+func (r RelativePointer) origin(base Pointer) (Pointer, error) {
+	if r.Up > len(base) {
+		return nil, fmt.Errorf("Cannot go up %v levels from %v", r.Up, base.String())
+	}
+	res := base
+	for i := 0; i < r.Up; i++ {
+		_, res = res.Chop()
+	}
+	if r.Offset == 0 {
+		return res, nil
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("Cannot offset the index of the whole document")
+	}
+	last, parent := res.Chop()
+	index, err := strconv.Atoi(last)
+	if err != nil || index < 0 {
+		return nil, fmt.Errorf("Cannot offset %v, which is not an array index", res.String())
+	}
+	if index+r.Offset < 0 {
+		return nil, fmt.Errorf("Offsetting %v by %v is out of bounds", res.String(), r.Offset)
+	}
+	return parent.Append(strconv.Itoa(index + r.Offset)), nil
+}
+
+// Resolve returns the absolute pointer that r refers to when it is
+// relative to base.  Since it does not look at a document, it cannot
+// check that the location exists.  A relative pointer ending in `#`
+// does not refer to a location, so Resolve returns the location whose
+// name or index it refers to instead.
+func (r RelativePointer) Resolve(base Pointer) (Pointer, error) {
+	origin, err := r.origin(base)
+	if err != nil {
+		return nil, err
+	}
+	res := make(Pointer, 0, len(origin)+len(r.Pointer))
+	return append(append(res, origin...), r.Pointer...), nil
+}
+
+// Get returns the value that r refers to in doc when it is relative
+// to base.  If r ends in `#`, the value is the member name of the
+// location as a string, or its array index as a number.
+func (r RelativePointer) Get(doc interface{}, base Pointer) (interface{}, error) {
+	if _, err := base.Get(doc); err != nil {
+		return nil, err
+	}
+	origin, err := r.origin(base)
+	if err != nil {
+		return nil, err
+	}
+	if r.Offset != 0 {
+		// Index manipulation only makes sense inside arrays.
+		_, parent := origin.Chop()
+		container, err := parent.Get(doc)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := container.([]interface{}); !ok {
+			return nil, fmt.Errorf("Cannot offset %v, which is not in an array", origin.String())
+		}
+	}
+	if !r.Key {
+		target := make(Pointer, 0, len(origin)+len(r.Pointer))
+		return append(append(target, origin...), r.Pointer...).Get(doc)
+	}
+	if len(origin) == 0 {
+		return nil, fmt.Errorf("The whole document has no name or index")
+	}
+	if _, err := origin.Get(doc); err != nil {
+		return nil, err
+	}
+	last, parent := origin.Chop()
+	container, _ := parent.Get(doc)
+	if _, ok := container.([]interface{}); ok {
+		index, _ := strconv.Atoi(last)
+		return float64(index), nil
+	}
+	return last, nil
+}
@@ -0,0 +1,93 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type relPtrTest struct {
+	base  string
+	rel   string
+	value interface{}
+	valid bool
+}
+
+// These are mostly the examples from the draft.
+var relPtrTests = []relPtrTest{
+	{`/foo/1`, `0`, "baz", true},
+	{`/foo/1`, `1/0`, "bar", true},
+	{`/foo/1`, `0-1`, "bar", true},
+	{`/foo/0`, `0+1`, "baz", true},
+	{`/foo/1`, `2/highly/nested/objects`, true, true},
+	{`/foo/1`, `0#`, float64(1), true},
+	{`/foo/1`, `0-1#`, float64(0), true},
+	{`/foo/1`, `1#`, "foo", true},
+	{`/highly/nested`, `0/objects`, true, true},
+	{`/highly/nested`, `1/nested/objects`, true, true},
+	{`/highly/nested`, `2/foo/0`, "bar", true},
+	{`/highly/nested`, `0#`, "nested", true},
+	{`/highly/nested`, `1#`, "highly", true},
+	{`/highly/nested`, `2#`, nil, false},
+	{`/highly/nested`, `3`, nil, false},
+	{`/highly/nested`, `0+1`, nil, false},
+	{`/foo/1`, `0+1`, nil, false},
+	{`/foo/0`, `0-1`, nil, false},
+	{`/foo/1`, `01`, nil, false},
+	{`/foo/1`, `a`, nil, false},
+	{`/foo/1`, `0x`, nil, false},
+	{`/foo/1`, `0+`, nil, false},
+	{`/foo/1`, `0#/a`, nil, false},
+	{`/nope`, `0`, nil, false},
+	{`/items/0`, `0+1/name`, "b", true},
+	{`/items/1/name`, `1-1/name`, "a", true},
+	{`/items/1/name`, `1+1/name`, nil, false},
+	{`/highly/nested`, `0+1/objects`, nil, false},
+}
+
+func TestRelativePointers(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"foo":["bar","baz"],"highly":{"nested":{"objects":true}},"items":[{"name":"a"},{"name":"b"}]}`), &doc)
+	for _, test := range relPtrTests {
+		base, err := NewPointer(test.base)
+		if err != nil {
+			t.Fatalf("Bad test base `%v` (%v)", test.base, err)
+		}
+		rel, err := NewRelativePointer(test.rel)
+		var val interface{}
+		if err == nil {
+			val, err = rel.Get(doc, base)
+		}
+		if !test.valid {
+			if err == nil {
+				t.Errorf("`%v` from `%v` should have failed, got %#v", test.rel, test.base, val)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("`%v` from `%v` failed (%v)", test.rel, test.base, err)
+			continue
+		}
+		if !reflect.DeepEqual(val, test.value) {
+			t.Errorf("`%v` from `%v` gave %#v, not %#v", test.rel, test.base, val, test.value)
+		}
+		if rel.String() != test.rel {
+			t.Errorf("`%v` stringified back to `%v`", test.rel, rel.String())
+		}
+	}
+}
+
+func TestRelativeResolve(t *testing.T) {
+	base, _ := NewPointer(`/a/b/3/c`)
+	rel, _ := NewRelativePointer(`1+2/d~1e`)
+	res, err := rel.Resolve(base)
+	if err != nil {
+		t.Fatalf("Failed to resolve `%v` (%v)", rel, err)
+	}
+	if res.String() != `/a/b/5/d~1e` {
+		t.Errorf("Resolved `%v` to `%v`", rel, res)
+	}
+	if base.String() != `/a/b/3/c` {
+		t.Errorf("Resolving modified the base pointer to `%v`", base)
+	}
+}
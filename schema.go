@@ -0,0 +1,647 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// Schema is a compiled JSON Schema.  Only a subset of draft 2020-12
+// is supported, which covers:
+//
+//   - boolean schemas, type, enum, const
+//   - properties, patternProperties, additionalProperties, required,
+//     dependentRequired, propertyNames, minProperties, maxProperties
+//   - prefixItems, items, contains, minItems, maxItems, uniqueItems
+//   - minLength, maxLength, pattern
+//   - minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//   - allOf, anyOf, oneOf, not, if, then, else
+//   - $defs, and $ref to JSON pointer fragments in the same schema
+//
+// Schemas that use the assertions this package does not understand,
+// such as unevaluatedProperties or $dynamicRef, are refused, since
+// ignoring them would let invalid documents through.  Annotations like
+// title, description, format, and readOnly are ignored when validating.
+type Schema struct {
+	doc      interface{}
+	patterns map[string]*regexp.Regexp
+}
+
+// unsupportedKeywords are assertions that NewSchema refuses.
+var unsupportedKeywords = []string{
+	"$dynamicRef", "$recursiveRef", "unevaluatedProperties",
+	"unevaluatedItems", "dependentSchemas", "minContains", "maxContains",
+}
+
+// NewSchema compiles a JSON Schema.  doc must be the result of
+// unmarshalling a JSON Schema into an interface{}.
+func NewSchema(doc interface{}) (*Schema, error) {
+	s := &Schema{doc: doc, patterns: map[string]*regexp.Regexp{}}
+	if err := s.compile(doc, make(Pointer, 0)); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSchemaJSON does the same thing as NewSchema, except buf should
+// contain the raw JSON Schema.
+func NewSchemaJSON(buf []byte) (*Schema, error) {
+	var doc interface{}
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, err
+	}
+	return NewSchema(doc)
+}
+
+// subschemaKeywords lists the keywords whose values are schemas,
+// arrays of schemas, or objects whose members are schemas.
+var subschemaKeywords = map[string]string{
+	"additionalProperties": "schema",
+	"items":                "schema",
+	"contains":             "schema",
+	"not":                  "schema",
+	"propertyNames":        "schema",
+	"if":                   "schema",
+	"then":                 "schema",
+	"else":                 "schema",
+	"prefixItems":          "array",
+	"allOf":                "array",
+	"anyOf":                "array",
+	"oneOf":                "array",
+	"properties":           "object",
+	"patternProperties":    "object",
+	"$defs":                "object",
+}
+
+// compile checks a schema and everything in it, and compiles any
+// regular expressions it contains.
+func (s *Schema) compile(schema interface{}, at Pointer) error {
+	if _, ok := schema.(bool); ok {
+		return nil
+	}
+	obj, ok := schema.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Schema at %v must be an object or a boolean", at.String())
+	}
+	for _, k := range unsupportedKeywords {
+		if _, ok := obj[k]; ok {
+			return fmt.Errorf("Schema at %v uses %v, which is not supported", at.String(), k)
+		}
+	}
+	if ref, ok := obj["$ref"]; ok {
+		refStr, ok := ref.(string)
+		if !ok {
+			return fmt.Errorf("$ref at %v must be a string", at.String())
+		}
+		if _, err := s.resolve(refStr); err != nil {
+			return err
+		}
+	}
+	if pat, ok := obj["pattern"]; ok {
+		if err := s.compilePattern(pat, at.Append("pattern")); err != nil {
+			return err
+		}
+	}
+	for _, k := range sortedKeys(obj) {
+		kind, ok := subschemaKeywords[k]
+		if !ok {
+			continue
+		}
+		where := at.Append(k)
+		switch kind {
+		case "schema":
+			if err := s.compile(obj[k], where); err != nil {
+				return err
+			}
+		case "array":
+			list, ok := obj[k].([]interface{})
+			if !ok {
+				return fmt.Errorf("%v must be an array", where.String())
+			}
+			for _, m := range children(where, list) {
+				if err := s.compile(m.val, m.ptr); err != nil {
+					return err
+				}
+			}
+		case "object":
+			members, ok := obj[k].(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("%v must be an object", where.String())
+			}
+			for _, m := range children(where, members) {
+				if k == "patternProperties" {
+					if err := s.compilePattern(string(m.ptr[len(m.ptr)-1]), m.ptr); err != nil {
+						return err
+					}
+				}
+				if err := s.compile(m.val, m.ptr); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) compilePattern(pat interface{}, at Pointer) error {
+	str, ok := pat.(string)
+	if !ok {
+		return fmt.Errorf("%v must be a string", at.String())
+	}
+	re, err := regexp.Compile(str)
+	if err != nil {
+		return fmt.Errorf("%v is not a valid regular expression: %v", at.String(), err)
+	}
+	s.patterns[str] = re
+	return nil
+}
+
+// resolve finds the schema a $ref refers to.
+func (s *Schema) resolve(ref string) (interface{}, error) {
+	ptr, err := NewPointerFromFragment(ref)
+	if err != nil {
+		return nil, fmt.Errorf("Only $refs to fragments of the same schema are supported, not `%v`", ref)
+	}
+	res, err := ptr.Get(s.doc)
+	if err != nil {
+		return nil, fmt.Errorf("$ref `%v` does not refer to anything: %v", ref, err)
+	}
+	return res, nil
+}
+
+// SchemaError describes a place where a document does not match a schema.
+type SchemaError struct {
+	// Path points to the value in the document that failed validation.
+	Path Pointer
+	// Keyword is the schema keyword that failed.
+	Keyword string
+	// Message describes the failure.
+	Message string
+	// Op is the index of the last operation in the patch that
+	// touched Path, or -1 if the error is not from applying a patch
+	// or no operation touched it.
+	Op int
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Path.String(), e.Message)
+}
+
+// ValidationError is returned when a document does not match a schema.
+type ValidationError struct {
+	Errors []SchemaError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		msgs[i] = e.Errors[i].Error()
+		if e.Errors[i].Op != -1 {
+			msgs[i] += fmt.Sprintf(" (from operation %v)", e.Errors[i].Op)
+		}
+	}
+	return "Document does not match schema: " + strings.Join(msgs, "; ")
+}
+
+// maxSchemaDepth limits how deeply $refs can recurse without making
+// progress through the document.
+const maxSchemaDepth = 256
+
+type validator struct {
+	s     *Schema
+	errs  []SchemaError
+	depth int
+}
+
+func (v *validator) fail(ptr Pointer, keyword, format string, args ...interface{}) {
+	v.errs = append(v.errs, SchemaError{ptr, keyword, fmt.Sprintf(format, args...), -1})
+}
+
+// passes checks val against schema without recording any errors.
+func (v *validator) passes(schema, val interface{}, ptr Pointer) bool {
+	sub := &validator{s: v.s, depth: v.depth}
+	sub.validate(schema, val, ptr)
+	return len(sub.errs) == 0
+}
+
+// Validate checks doc against the schema, and returns every place
+// where it does not match.  doc must be the result of unmarshalling
+// JSON into an interface{}, or utils.UnmarshalOrdered.
+func (s *Schema) Validate(doc interface{}) []SchemaError {
+	v := &validator{s: s}
+	v.validate(s.doc, utils.Plain(doc), make(Pointer, 0))
+	return v.errs
+}
+
+// jsonType returns the JSON Schema type name of val.
+func jsonType(val interface{}) string {
+	switch t := val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if t == math.Trunc(t) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", val)
+	}
+}
+
+func (v *validator) validate(schema, val interface{}, ptr Pointer) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			v.fail(ptr, "false", "no value is allowed here")
+		}
+		return
+	}
+	obj := schema.(map[string]interface{})
+	if ref, ok := obj["$ref"]; ok {
+		if v.depth >= maxSchemaDepth {
+			v.fail(ptr, "$ref", "schema recursion is too deep")
+			return
+		}
+		target, _ := v.s.resolve(ref.(string))
+		v.depth++
+		v.validate(target, val, ptr)
+		v.depth--
+	}
+	if t, ok := obj["type"]; ok {
+		v.checkType(t, val, ptr)
+	}
+	if enum, ok := obj["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if reflect.DeepEqual(e, val) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "enum", "value is not one of the allowed values")
+		}
+	}
+	if c, ok := obj["const"]; ok && !reflect.DeepEqual(c, val) {
+		v.fail(ptr, "const", "value must be %v", c)
+	}
+	switch t := val.(type) {
+	case map[string]interface{}:
+		v.validateObject(obj, t, ptr)
+	case []interface{}:
+		v.validateArray(obj, t, ptr)
+	case string:
+		v.validateString(obj, t, ptr)
+	case float64:
+		v.validateNumber(obj, t, ptr)
+	}
+	if all, ok := obj["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			v.validate(sub, val, ptr)
+		}
+	}
+	if anyOf, ok := obj["anyOf"].([]interface{}); ok {
+		found := false
+		for _, sub := range anyOf {
+			if v.passes(sub, val, ptr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "anyOf", "value does not match any of the allowed schemas")
+		}
+	}
+	if oneOf, ok := obj["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, sub := range oneOf {
+			if v.passes(sub, val, ptr) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(ptr, "oneOf", "value matches %v of the allowed schemas instead of exactly one", count)
+		}
+	}
+	if not, ok := obj["not"]; ok && v.passes(not, val, ptr) {
+		v.fail(ptr, "not", "value matches a schema it must not match")
+	}
+	if cond, ok := obj["if"]; ok {
+		if v.passes(cond, val, ptr) {
+			if then, ok := obj["then"]; ok {
+				v.validate(then, val, ptr)
+			}
+		} else if els, ok := obj["else"]; ok {
+			v.validate(els, val, ptr)
+		}
+	}
+}
+
+func (v *validator) checkType(t, val interface{}, ptr Pointer) {
+	actual := jsonType(val)
+	var allowed []interface{}
+	switch tt := t.(type) {
+	case string:
+		allowed = []interface{}{tt}
+	case []interface{}:
+		allowed = tt
+	}
+	for _, a := range allowed {
+		if a == actual || (a == "number" && actual == "integer") {
+			return
+		}
+	}
+	v.fail(ptr, "type", "value is %v, not %v", actual, t)
+}
+
+// number returns the numeric value of a schema keyword, if it has one.
+func number(obj map[string]interface{}, keyword string) (float64, bool) {
+	f, ok := obj[keyword].(float64)
+	return f, ok
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, obj map[string]interface{}, ptr Pointer) {
+	if req, ok := schema["required"].([]interface{}); ok {
+		for _, r := range req {
+			if name, ok := r.(string); ok {
+				if _, ok := obj[name]; !ok {
+					v.fail(ptr, "required", "member %v is required", name)
+				}
+			}
+		}
+	}
+	if deps, ok := schema["dependentRequired"].(map[string]interface{}); ok {
+		for _, k := range sortedKeys(deps) {
+			if _, ok := obj[k]; !ok {
+				continue
+			}
+			req, _ := deps[k].([]interface{})
+			for _, r := range req {
+				if name, ok := r.(string); ok {
+					if _, ok := obj[name]; !ok {
+						v.fail(ptr, "dependentRequired", "member %v is required when %v is present", name, k)
+					}
+				}
+			}
+		}
+	}
+	if n, ok := number(schema, "minProperties"); ok && float64(len(obj)) < n {
+		v.fail(ptr, "minProperties", "object must have at least %v members", n)
+	}
+	if n, ok := number(schema, "maxProperties"); ok && float64(len(obj)) > n {
+		v.fail(ptr, "maxProperties", "object must have at most %v members", n)
+	}
+	props, _ := schema["properties"].(map[string]interface{})
+	patProps, _ := schema["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := schema["additionalProperties"]
+	names, hasNames := schema["propertyNames"]
+	for _, k := range sortedKeys(obj) {
+		memberPtr := ptr.Append(k)
+		if hasNames && !v.passes(names, k, memberPtr) {
+			v.fail(memberPtr, "propertyNames", "member name %v is not allowed", k)
+		}
+		matched := false
+		if sub, ok := props[k]; ok {
+			matched = true
+			v.validate(sub, obj[k], memberPtr)
+		}
+		for _, pat := range sortedKeys(patProps) {
+			if v.s.patterns[pat].MatchString(k) {
+				matched = true
+				v.validate(patProps[pat], obj[k], memberPtr)
+			}
+		}
+		if !matched && hasAdditional {
+			if b, ok := additional.(bool); ok && !b {
+				v.fail(memberPtr, "additionalProperties", "member %v is not allowed", k)
+			} else {
+				v.validate(additional, obj[k], memberPtr)
+			}
+		}
+	}
+}
+
+func (v *validator) validateArray(schema map[string]interface{}, list []interface{}, ptr Pointer) {
+	if n, ok := number(schema, "minItems"); ok && float64(len(list)) < n {
+		v.fail(ptr, "minItems", "array must have at least %v elements", n)
+	}
+	if n, ok := number(schema, "maxItems"); ok && float64(len(list)) > n {
+		v.fail(ptr, "maxItems", "array must have at most %v elements", n)
+	}
+	if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+	outer:
+		for i := range list {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(list[i], list[j]) {
+					v.fail(ptr, "uniqueItems", "elements %v and %v are the same", j, i)
+					break outer
+				}
+			}
+		}
+	}
+	prefix, _ := schema["prefixItems"].([]interface{})
+	for i := range list {
+		elemPtr := ptr.Append(fmt.Sprint(i))
+		if i < len(prefix) {
+			v.validate(prefix[i], list[i], elemPtr)
+		} else if items, ok := schema["items"]; ok {
+			v.validate(items, list[i], elemPtr)
+		}
+	}
+	if contains, ok := schema["contains"]; ok {
+		found := false
+		for i := range list {
+			if v.passes(contains, list[i], ptr.Append(fmt.Sprint(i))) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(ptr, "contains", "array does not contain a matching element")
+		}
+	}
+}
+
+func (v *validator) validateString(schema map[string]interface{}, str string, ptr Pointer) {
+	length := float64(utf8.RuneCountInString(str))
+	if n, ok := number(schema, "minLength"); ok && length < n {
+		v.fail(ptr, "minLength", "string must be at least %v characters long", n)
+	}
+	if n, ok := number(schema, "maxLength"); ok && length > n {
+		v.fail(ptr, "maxLength", "string must be at most %v characters long", n)
+	}
+	if pat, ok := schema["pattern"].(string); ok && !v.s.patterns[pat].MatchString(str) {
+		v.fail(ptr, "pattern", "string does not match %v", pat)
+	}
+}
+
+func (v *validator) validateNumber(schema map[string]interface{}, f float64, ptr Pointer) {
+	if n, ok := number(schema, "minimum"); ok && f < n {
+		v.fail(ptr, "minimum", "value must be at least %v", n)
+	}
+	if n, ok := number(schema, "maximum"); ok && f > n {
+		v.fail(ptr, "maximum", "value must be at most %v", n)
+	}
+	if n, ok := number(schema, "exclusiveMinimum"); ok && f <= n {
+		v.fail(ptr, "exclusiveMinimum", "value must be more than %v", n)
+	}
+	if n, ok := number(schema, "exclusiveMaximum"); ok && f >= n {
+		v.fail(ptr, "exclusiveMaximum", "value must be less than %v", n)
+	}
+	if n, ok := number(schema, "multipleOf"); ok && n > 0 {
+		q := f / n
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			v.fail(ptr, "multipleOf", "value must be a multiple of %v", n)
+		}
+	}
+}
+
+// touches returns how closely op is related to the value at ptr: 2 if
+// it changed the value directly, by replacing it or one of its parents
+// or by adding or removing one of its members, 1 if it only changed
+// something inside the value, and 0 if it did neither.
+func touches(op *Operation, ptr Pointer) int {
+	res := 0
+	locs := []Pointer{op.Path}
+	if op.Op == "move" {
+		locs = append(locs, op.From)
+	}
+	for _, loc := range locs {
+		switch {
+		case op.Op != "replace" && len(loc) == len(ptr)+1 && loc[:len(ptr)].Equal(ptr):
+			return 2
+		case len(loc) <= len(ptr) && loc.Equal(ptr[:len(loc)]):
+			return 2
+		case len(loc) > len(ptr) && loc[:len(ptr)].Equal(ptr):
+			res = 1
+		}
+	}
+	return res
+}
+
+// attribute sets the Op of each error to the index of the last
+// operation in p that changed the value at its path directly, or if
+// there was none, the last one that changed something inside it.
+func attribute(errs []SchemaError, p Patch) {
+	for i := range errs {
+		errs[i].Op = -1
+		best := 0
+		for j := len(p) - 1; j >= 0 && best < 2; j-- {
+			if p[j].Op == "test" {
+				continue
+			}
+			if t := touches(&p[j], errs[i].Path); t > best {
+				best = t
+				errs[i].Op = j
+			}
+		}
+	}
+}
+
+// schemaSet is the list of object schemas that apply to one location
+// in a document.  It is used to look up annotations while generating
+// patches, so it only follows $ref and allOf; schemas under anyOf and
+// oneOf may or may not apply, and are left out.
+type schemaSet []map[string]interface{}
+
+// flatten adds schema, and every schema it pulls in with $ref or
+// allOf, to set.
+func (s *Schema) flatten(set schemaSet, schema interface{}, depth int) schemaSet {
+	obj, ok := schema.(map[string]interface{})
+	if !ok || depth >= maxSchemaDepth {
+		return set
+	}
+	set = append(set, obj)
+	if ref, ok := obj["$ref"].(string); ok {
+		target, _ := s.resolve(ref)
+		set = s.flatten(set, target, depth+1)
+	}
+	if all, ok := obj["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			set = s.flatten(set, sub, depth+1)
+		}
+	}
+	return set
+}
+
+// rootSet returns the schemas that apply to the whole document.
+func (s *Schema) rootSet() schemaSet {
+	return s.flatten(nil, s.doc, 0)
+}
+
+// memberSet returns the schemas that apply to the member named key of
+// an object that set applies to.
+func (s *Schema) memberSet(set schemaSet, key string) schemaSet {
+	var res schemaSet
+	for _, obj := range set {
+		matched := false
+		if props, ok := obj["properties"].(map[string]interface{}); ok {
+			if sub, ok := props[key]; ok {
+				matched = true
+				res = s.flatten(res, sub, 0)
+			}
+		}
+		if patProps, ok := obj["patternProperties"].(map[string]interface{}); ok {
+			for _, pat := range sortedKeys(patProps) {
+				if s.patterns[pat].MatchString(key) {
+					matched = true
+					res = s.flatten(res, patProps[pat], 0)
+				}
+			}
+		}
+		if additional, ok := obj["additionalProperties"]; ok && !matched {
+			res = s.flatten(res, additional, 0)
+		}
+	}
+	return res
+}
+
+// elementSet returns the schemas that apply to the element at index of
+// an array that set applies to.
+func (s *Schema) elementSet(set schemaSet, index int) schemaSet {
+	var res schemaSet
+	for _, obj := range set {
+		prefix, _ := obj["prefixItems"].([]interface{})
+		if index < len(prefix) {
+			res = s.flatten(res, prefix[index], 0)
+		} else if items, ok := obj["items"]; ok {
+			res = s.flatten(res, items, 0)
+		}
+	}
+	return res
+}
+
+// keyword returns the value of the first keyword named k in the set.
+func (set schemaSet) keyword(k string) (interface{}, bool) {
+	for _, obj := range set {
+		if v, ok := obj[k]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// valid returns true if val at ptr matches every schema in the set.
+func (s *Schema) valid(set schemaSet, val interface{}, ptr Pointer) bool {
+	v := &validator{s: s}
+	val = utils.Plain(val)
+	for _, obj := range set {
+		if !v.passes(obj, val, ptr) {
+			return false
+		}
+	}
+	return true
+}
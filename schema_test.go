@@ -0,0 +1,146 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type schemaTest struct {
+	schema string
+	doc    string
+	errors []string
+}
+
+var schemaTests = []schemaTest{
+	{`true`, `{"a":1}`, []string{}},
+	{`false`, `1`, []string{`:false`}},
+	{`{"type":"integer"}`, `1`, []string{}},
+	{`{"type":"integer"}`, `1.5`, []string{`:type`}},
+	{`{"type":"number"}`, `1`, []string{}},
+	{`{"type":["string","null"]}`, `null`, []string{}},
+	{`{"type":["string","null"]}`, `true`, []string{`:type`}},
+	{`{"enum":["a",{"b":1}]}`, `{"b":1}`, []string{}},
+	{`{"enum":["a",{"b":1}]}`, `"b"`, []string{`:enum`}},
+	{`{"const":5}`, `6`, []string{`:const`}},
+	{
+		`{"type":"object","required":["a","b"],"properties":{"a":{"type":"string"}},"additionalProperties":false}`,
+		`{"a":1,"c":2}`,
+		[]string{`:required`, `/a:type`, `/c:additionalProperties`},
+	},
+	{
+		`{"patternProperties":{"^x-":{"type":"string"}},"additionalProperties":{"type":"number"}}`,
+		`{"x-a":"ok","x-b":1,"y":2,"z":"no"}`,
+		[]string{`/x-b:type`, `/z:type`},
+	},
+	{`{"propertyNames":{"maxLength":2},"minProperties":3}`, `{"ab":1,"abc":2}`, []string{`:minProperties`, `/abc:propertyNames`}},
+	{`{"maxProperties":1}`, `{"a":1,"b":2}`, []string{`:maxProperties`}},
+	{`{"dependentRequired":{"a":["b"]}}`, `{"a":1}`, []string{`:dependentRequired`}},
+	{
+		`{"prefixItems":[{"type":"string"}],"items":{"type":"number"},"minItems":2,"uniqueItems":true}`,
+		`["a","b",1,1]`,
+		[]string{`:uniqueItems`, `/1:type`},
+	},
+	{`{"maxItems":1,"contains":{"const":3}}`, `[1,2]`, []string{`:maxItems`, `:contains`}},
+	{`{"minLength":2,"maxLength":3,"pattern":"^a"}`, `"bcde"`, []string{`:maxLength`, `:pattern`}},
+	{`{"minLength":2}`, `"é"`, []string{`:minLength`}},
+	{`{"minimum":1,"exclusiveMaximum":5,"multipleOf":0.5}`, `5`, []string{`:exclusiveMaximum`}},
+	{`{"maximum":1,"exclusiveMinimum":0,"multipleOf":0.5}`, `0`, []string{`:exclusiveMinimum`}},
+	{`{"multipleOf":0.1}`, `0.3`, []string{}},
+	{`{"multipleOf":2}`, `3`, []string{`:multipleOf`}},
+	{`{"allOf":[{"minimum":1},{"maximum":0}]}`, `2`, []string{`:maximum`}},
+	{`{"anyOf":[{"type":"string"},{"minimum":5}]}`, `2`, []string{`:anyOf`}},
+	{`{"oneOf":[{"type":"number"},{"minimum":1}]}`, `2`, []string{`:oneOf`}},
+	{`{"not":{"type":"string"}}`, `"a"`, []string{`:not`}},
+	{`{"if":{"required":["a"]},"then":{"required":["b"]},"else":{"required":["c"]}}`, `{"a":1}`, []string{`:required`}},
+	{`{"if":{"required":["a"]},"then":{"required":["b"]},"else":{"required":["c"]}}`, `{}`, []string{`:required`}},
+	{
+		`{"$defs":{"node":{"type":"object","properties":{"next":{"$ref":"#/$defs/node"},"v":{"type":"number"}}}},"$ref":"#/$defs/node"}`,
+		`{"v":1,"next":{"v":2,"next":{"v":"x"}}}`,
+		[]string{`/next/next/v:type`},
+	},
+}
+
+func TestSchemaValidate(t *testing.T) {
+	for _, test := range schemaTests {
+		s, err := NewSchemaJSON([]byte(test.schema))
+		if err != nil {
+			t.Errorf("Failed to compile schema `%v` (%v)", test.schema, err)
+			continue
+		}
+		var doc interface{}
+		json.Unmarshal([]byte(test.doc), &doc)
+		found := make([]string, 0)
+		for _, e := range s.Validate(doc) {
+			found = append(found, e.Path.String()+":"+e.Keyword)
+		}
+		if !reflect.DeepEqual(found, test.errors) {
+			t.Errorf("Validating `%v` against `%v` gave %#v, not %#v", test.doc, test.schema, found, test.errors)
+		}
+	}
+}
+
+func TestBadSchemas(t *testing.T) {
+	schemas := []string{
+		`5`,
+		`{"properties":[]}`,
+		`{"allOf":{}}`,
+		`{"pattern":"("}`,
+		`{"patternProperties":{"(":true}}`,
+		`{"$ref":"#/nowhere"}`,
+		`{"$ref":"http://example.com/schema"}`,
+		`{"unevaluatedProperties":false}`,
+		`{"items":{"not":3}}`,
+	}
+	for _, schema := range schemas {
+		if _, err := NewSchemaJSON([]byte(schema)); err == nil {
+			t.Errorf("Expected schema `%v` to be refused", schema)
+		}
+	}
+}
+
+func TestApplyWithSchema(t *testing.T) {
+	s, err := NewSchemaJSON([]byte(`{
+		"type":"object",
+		"required":["name","spec"],
+		"properties":{
+			"name":{"type":"string"},
+			"spec":{"type":"object","properties":{"replicas":{"type":"integer","minimum":0}}}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to compile schema (%v)", err)
+	}
+	src := []byte(`{"name":"x","spec":{"replicas":1}}`)
+	opts := ApplyOptions{Schema: s}
+	p := []byte(`[{"op":"replace","path":"/spec/replicas","value":3},{"op":"add","path":"/other","value":1}]`)
+	if _, err, idx := ApplyJSONWithOptions(src, p, opts); err != nil {
+		t.Errorf("Failed to apply valid patch at operation %v (%v)", idx, err)
+	}
+	p = []byte(`[
+		{"op":"add","path":"/other","value":1},
+		{"op":"replace","path":"/spec/replicas","value":3},
+		{"op":"remove","path":"/name"},
+		{"op":"test","path":"/spec/replicas","value":3},
+		{"op":"replace","path":"/spec","value":{"replicas":-1}}
+	]`)
+	_, err, idx := ApplyJSONWithOptions(src, p, opts)
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Expected a *ValidationError, got %#v", err)
+	}
+	if idx != 2 {
+		t.Errorf("Expected the failure to be attributed to operation 2, not %v", idx)
+	}
+	found := make([]SchemaError, len(verr.Errors))
+	for i, e := range verr.Errors {
+		found[i] = SchemaError{Path: e.Path, Keyword: e.Keyword, Op: e.Op}
+	}
+	expected := []SchemaError{
+		{Path: Pointer{}, Keyword: "required", Op: 2},
+		{Path: Pointer{"spec", "replicas"}, Keyword: "minimum", Op: 4},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Got schema errors %#v, not %#v", found, expected)
+	}
+}
@@ -0,0 +1,452 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+// StrategicContentType is the media type of a strategic merge patch.
+const StrategicContentType = "application/strategic-merge-patch+json"
+
+// Strategic merge patches look like JSON Merge Patches (RFC 7386), but
+// arrays of objects can be merged element by element instead of being
+// replaced wholesale, and the patch can contain directives that
+// control how each part of it is handled:
+//
+//   - `"$patch": "replace"` in an object replaces the original object
+//     with the patch object instead of merging them.
+//   - `"$patch": "delete"` in an object deletes the original object.
+//     In a keyed array, it deletes the element with the same key.
+//   - `"$patch": "merge"` in an object is the default behaviour.
+//   - `{"$patch": "replace"}` as an element of an array replaces the
+//     original array with the rest of the elements.
+//   - `"$setElementOrder/<field>": [...]` in an object reorders the
+//     keyed array in field to match the keys listed in it.
+//
+// Unlike the Kubernetes implementation, nothing here looks at Go
+// struct tags.  Which arrays are merged by key, and what the key is,
+// comes from a StrategicSchema.
+
+const (
+	patchDirective    = "$patch"
+	setOrderDirective = "$setElementOrder/"
+)
+
+// StrategicSchema describes how the arrays in a document should be
+// handled by strategic merge patches.
+type StrategicSchema struct {
+	// MergeKeys maps JSON pointers to arrays of objects onto the name
+	// of the member that identifies each object in the array, as in
+	// GenerateOptions.MergeKeys.  Use `*` to match array indexes,
+	// since they are not stable while merging.  Arrays without a
+	// merge key are replaced wholesale.
+	MergeKeys map[string]string
+}
+
+// strategic holds the parsed form of a StrategicSchema.
+type strategic struct {
+	keys []mergeKey
+}
+
+func newStrategic(schema StrategicSchema) (*strategic, error) {
+	res := &strategic{}
+	pats := make([]string, 0, len(schema.MergeKeys))
+	for pat := range schema.MergeKeys {
+		pats = append(pats, pat)
+	}
+	sort.Strings(pats)
+	for _, pat := range pats {
+		mk, err := newPattern(pat)
+		if err != nil {
+			return nil, err
+		}
+		res.keys = append(res.keys, mergeKey{mk, schema.MergeKeys[pat]})
+	}
+	return res, nil
+}
+
+// mergeKey returns the merge key for the array at ptr, if it has one.
+func (s *strategic) mergeKey(ptr Pointer) (string, bool) {
+	for _, mk := range s.keys {
+		if mk.pat.Matches(ptr) {
+			return mk.key, true
+		}
+	}
+	return "", false
+}
+
+// idOf returns the identity of an element of a keyed array.
+func idOf(val interface{}, key string) (string, bool) {
+	ids, _, ok := identify([]interface{}{val}, key)
+	if !ok {
+		return "", false
+	}
+	return ids[0], true
+}
+
+// directive returns the value of the $patch directive in val, if any.
+func directive(val interface{}) (string, error) {
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+	d, ok := obj[patchDirective]
+	if !ok {
+		return "", nil
+	}
+	switch d {
+	case "replace", "delete", "merge":
+		return d.(string), nil
+	default:
+		return "", fmt.Errorf("Invalid %v directive %#v", patchDirective, d)
+	}
+}
+
+// stripDirectives returns a copy of val without any directives in it.
+func stripDirectives(val interface{}) interface{} {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			if k == patchDirective || strings.HasPrefix(k, setOrderDirective) {
+				continue
+			}
+			res[k] = stripDirectives(v)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, 0, len(t))
+		for _, v := range t {
+			if obj, ok := v.(map[string]interface{}); ok && len(obj) == 1 && obj[patchDirective] != nil {
+				continue
+			}
+			res = append(res, stripDirectives(v))
+		}
+		return res
+	default:
+		return val
+	}
+}
+
+// merge merges patch into orig, returning the result.  If keep is
+// false, the patch deleted orig entirely.
+func (s *strategic) merge(orig, patch interface{}, ptr Pointer) (res interface{}, keep bool, err error) {
+	switch p := patch.(type) {
+	case map[string]interface{}:
+		o, _ := orig.(map[string]interface{})
+		return s.mergeObject(o, p, ptr)
+	case []interface{}:
+		o, _ := orig.([]interface{})
+		res, err := s.mergeList(o, p, ptr)
+		return res, true, err
+	default:
+		return utils.Clone(patch), true, nil
+	}
+}
+
+func (s *strategic) mergeObject(orig, patch map[string]interface{}, ptr Pointer) (interface{}, bool, error) {
+	d, err := directive(patch)
+	if err != nil {
+		return nil, false, err
+	}
+	switch d {
+	case "delete":
+		return nil, false, nil
+	case "replace":
+		return stripDirectives(patch), true, nil
+	}
+	res := make(map[string]interface{}, len(orig))
+	for k, v := range orig {
+		res[k] = utils.Clone(v)
+	}
+	for _, k := range sortedKeys(patch) {
+		if k == patchDirective || strings.HasPrefix(k, setOrderDirective) {
+			continue
+		}
+		v := patch[k]
+		if v == nil {
+			delete(res, k)
+			continue
+		}
+		merged, keep, err := s.merge(res[k], v, ptr.Append(k))
+		if err != nil {
+			return nil, false, err
+		}
+		if keep {
+			res[k] = merged
+		} else {
+			delete(res, k)
+		}
+	}
+	for _, k := range sortedKeys(patch) {
+		if !strings.HasPrefix(k, setOrderDirective) {
+			continue
+		}
+		field := strings.TrimPrefix(k, setOrderDirective)
+		if err := s.setOrder(res, field, patch[k], ptr.Append(field)); err != nil {
+			return nil, false, err
+		}
+	}
+	return res, true, nil
+}
+
+// setOrder reorders the keyed array in obj[field] to match order.
+// Elements that are not mentioned in order keep their relative order
+// and go after the ones that are.
+func (s *strategic) setOrder(obj map[string]interface{}, field string, order interface{}, ptr Pointer) error {
+	key, ok := s.mergeKey(ptr)
+	if !ok {
+		return fmt.Errorf("%v%v refers to %v, which has no merge key", setOrderDirective, field, ptr.String())
+	}
+	wanted, ok := order.([]interface{})
+	if !ok {
+		return fmt.Errorf("%v%v must be an array", setOrderDirective, field)
+	}
+	list, ok := obj[field].([]interface{})
+	if !ok {
+		return nil
+	}
+	rank := make(map[string]int, len(wanted))
+	for i, v := range wanted {
+		id, ok := idOf(v, key)
+		if !ok {
+			return fmt.Errorf("%v%v element %v has no `%v`", setOrderDirective, field, i, key)
+		}
+		rank[id] = i
+	}
+	ranked := func(v interface{}) int {
+		if id, ok := idOf(v, key); ok {
+			if r, ok := rank[id]; ok {
+				return r
+			}
+		}
+		return len(wanted)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return ranked(list[i]) < ranked(list[j])
+	})
+	return nil
+}
+
+func (s *strategic) mergeList(orig, patch []interface{}, ptr Pointer) (interface{}, error) {
+	for i, v := range patch {
+		d, err := directive(v)
+		if err != nil {
+			return nil, err
+		}
+		if d == "replace" && len(v.(map[string]interface{})) == 1 {
+			return stripDirectives(append(append([]interface{}{}, patch[:i]...), patch[i+1:]...)), nil
+		}
+	}
+	key, ok := s.mergeKey(ptr)
+	if !ok {
+		return stripDirectives(patch), nil
+	}
+	res := make([]interface{}, len(orig))
+	for i := range orig {
+		res[i] = utils.Clone(orig[i])
+	}
+	elemPtr := ptr.Append("*")
+	for i, v := range patch {
+		id, ok := idOf(v, key)
+		if !ok {
+			return nil, fmt.Errorf("Element %v of the patch for %v has no `%v`", i, ptr.String(), key)
+		}
+		found := -1
+		for j := range res {
+			if other, ok := idOf(res[j], key); ok && other == id {
+				found = j
+				break
+			}
+		}
+		if found == -1 {
+			if d, _ := directive(v); d != "delete" {
+				res = append(res, stripDirectives(v))
+			}
+			continue
+		}
+		merged, keep, err := s.merge(res[found], v, elemPtr)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			res[found] = merged
+		} else {
+			res = append(res[:found], res[found+1:]...)
+		}
+	}
+	return res, nil
+}
+
+// diff creates a strategic merge patch that turns orig into mod.
+func (s *strategic) diff(orig, mod interface{}, ptr Pointer) (interface{}, bool) {
+	if o, ok := orig.(map[string]interface{}); ok {
+		if m, ok := mod.(map[string]interface{}); ok {
+			res := s.diffObject(o, m, ptr)
+			return res, len(res) > 0
+		}
+	}
+	if reflect.DeepEqual(orig, mod) {
+		return nil, false
+	}
+	if m, ok := mod.([]interface{}); ok {
+		if _, ok := s.mergeKey(ptr); ok {
+			// A keyed array that replaces something else still
+			// needs to be protected from being merged.
+			return append([]interface{}{map[string]interface{}{patchDirective: "replace"}}, utils.Clone(m).([]interface{})...), true
+		}
+	}
+	return utils.Clone(mod), true
+}
+
+func (s *strategic) diffObject(orig, mod map[string]interface{}, ptr Pointer) map[string]interface{} {
+	res := make(map[string]interface{})
+	for k := range orig {
+		if _, ok := mod[k]; !ok {
+			res[k] = nil
+		}
+	}
+	for k, m := range mod {
+		o, ok := orig[k]
+		if !ok {
+			res[k] = utils.Clone(m)
+			continue
+		}
+		fieldPtr := ptr.Append(k)
+		ol, oIsList := o.([]interface{})
+		ml, mIsList := m.([]interface{})
+		if key, hasKey := s.mergeKey(fieldPtr); hasKey && oIsList && mIsList {
+			if list, order, ok := s.diffList(ol, ml, key, fieldPtr); ok {
+				if len(list) > 0 {
+					res[k] = list
+				}
+				if order != nil {
+					res[setOrderDirective+k] = order
+				}
+				continue
+			}
+		}
+		if sub, changed := s.diff(o, m, fieldPtr); changed {
+			res[k] = sub
+		}
+	}
+	return res
+}
+
+// diffList diffs two keyed arrays, returning the list of element
+// patches and the $setElementOrder list, if one is needed.  If the
+// elements cannot be identified, diffList returns false.
+func (s *strategic) diffList(orig, mod []interface{}, key string, ptr Pointer) ([]interface{}, []interface{}, bool) {
+	origIDs, origIdx, ok := identify(orig, key)
+	if !ok {
+		return nil, nil, false
+	}
+	modIDs, modIdx, ok := identify(mod, key)
+	if !ok {
+		return nil, nil, false
+	}
+	elemPtr := ptr.Append("*")
+	res := make([]interface{}, 0)
+	naive := make([]string, 0, len(mod))
+	for i, id := range origIDs {
+		keyVal := orig[i].(map[string]interface{})[key]
+		j, ok := modIdx[id]
+		if !ok {
+			res = append(res, map[string]interface{}{key: utils.Clone(keyVal), patchDirective: "delete"})
+			continue
+		}
+		naive = append(naive, id)
+		sub := s.diffObject(orig[i].(map[string]interface{}), mod[j].(map[string]interface{}), elemPtr)
+		if len(sub) > 0 {
+			sub[key] = utils.Clone(keyVal)
+			res = append(res, sub)
+		}
+	}
+	for i, id := range modIDs {
+		if _, ok := origIdx[id]; !ok {
+			naive = append(naive, id)
+			res = append(res, utils.Clone(mod[i]))
+		}
+	}
+	var order []interface{}
+	if !reflect.DeepEqual(naive, modIDs) {
+		order = make([]interface{}, len(mod))
+		for i := range mod {
+			order[i] = map[string]interface{}{key: utils.Clone(mod[i].(map[string]interface{})[key])}
+		}
+	}
+	return res, order, true
+}
+
+// ApplyStrategic applies a strategic merge patch to original, using
+// schema to decide how arrays are merged.  original is not modified.
+func ApplyStrategic(original, patch interface{}, schema StrategicSchema) (interface{}, error) {
+	s, err := newStrategic(schema)
+	if err != nil {
+		return nil, err
+	}
+	res, keep, err := s.merge(original, patch, make(Pointer, 0))
+	if err != nil {
+		return nil, err
+	}
+	if !keep {
+		return map[string]interface{}{}, nil
+	}
+	return res, nil
+}
+
+// ApplyStrategicJSON does the same thing as ApplyStrategic, except the
+// inputs and output are JSON-containing byte arrays.
+func ApplyStrategicJSON(original, patch []byte, schema StrategicSchema) ([]byte, error) {
+	var rawOriginal, rawPatch interface{}
+	if err := json.Unmarshal(original, &rawOriginal); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &rawPatch); err != nil {
+		return nil, err
+	}
+	res, err := ApplyStrategic(rawOriginal, rawPatch, schema)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
+
+// GenerateStrategic creates a strategic merge patch that will turn
+// original into modified when applied with the same schema.
+//
+// As with JSON Merge Patches, null values in modified cannot be
+// represented, since a null in the patch deletes the member.
+func GenerateStrategic(original, modified interface{}, schema StrategicSchema) (interface{}, error) {
+	s, err := newStrategic(schema)
+	if err != nil {
+		return nil, err
+	}
+	res, changed := s.diff(original, modified, make(Pointer, 0))
+	if !changed {
+		return map[string]interface{}{}, nil
+	}
+	return res, nil
+}
+
+// GenerateStrategicJSON does the same thing as GenerateStrategic,
+// except the inputs and output are JSON-containing byte arrays.
+func GenerateStrategicJSON(original, modified []byte, schema StrategicSchema) ([]byte, error) {
+	var rawOriginal, rawModified interface{}
+	if err := json.Unmarshal(original, &rawOriginal); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(modified, &rawModified); err != nil {
+		return nil, err
+	}
+	res, err := GenerateStrategic(rawOriginal, rawModified, schema)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(res)
+}
@@ -0,0 +1,152 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var strategicSchema = StrategicSchema{
+	MergeKeys: map[string]string{
+		"/containers":         "name",
+		"/containers/*/ports": "port",
+	},
+}
+
+type strategicTest struct {
+	desc   string
+	src    string
+	patch  string
+	final  string
+	create bool
+}
+
+var strategicTests = []strategicTest{
+	{
+		`Objects merge like merge patches`,
+		`{"a":1,"b":{"c":2,"d":3}}`,
+		`{"a":null,"b":{"c":4},"e":5}`,
+		`{"b":{"c":4,"d":3},"e":5}`,
+		true,
+	},
+	{
+		`Unkeyed arrays are replaced`,
+		`{"l":[1,2,3]}`,
+		`{"l":[4]}`,
+		`{"l":[4]}`,
+		true,
+	},
+	{
+		`Keyed arrays are merged by key`,
+		`{"containers":[{"name":"a","image":"x"},{"name":"b","image":"y"}]}`,
+		`{"containers":[{"image":"z","name":"b"},{"image":"w","name":"c"}]}`,
+		`{"containers":[{"name":"a","image":"x"},{"name":"b","image":"z"},{"name":"c","image":"w"}]}`,
+		true,
+	},
+	{
+		`Deleting keyed elements`,
+		`{"containers":[{"name":"a"},{"name":"b"}]}`,
+		`{"containers":[{"$patch":"delete","name":"a"}]}`,
+		`{"containers":[{"name":"b"}]}`,
+		true,
+	},
+	{
+		`Nested keyed arrays`,
+		`{"containers":[{"name":"a","ports":[{"port":80,"proto":"tcp"}]}]}`,
+		`{"containers":[{"name":"a","ports":[{"port":80,"proto":"udp"},{"port":443}]}]}`,
+		`{"containers":[{"name":"a","ports":[{"port":80,"proto":"udp"},{"port":443}]}]}`,
+		false,
+	},
+	{
+		`Setting element order`,
+		`{"containers":[{"name":"a"},{"name":"b"}]}`,
+		`{"$setElementOrder/containers":[{"name":"c"},{"name":"b"},{"name":"a"}],"containers":[{"name":"c"}]}`,
+		`{"containers":[{"name":"c"},{"name":"b"},{"name":"a"}]}`,
+		true,
+	},
+	{
+		`Replacing an object`,
+		`{"a":{"b":1,"c":2}}`,
+		`{"a":{"$patch":"replace","d":3}}`,
+		`{"a":{"d":3}}`,
+		false,
+	},
+	{
+		`Deleting an object`,
+		`{"a":{"b":1},"c":1}`,
+		`{"a":{"$patch":"delete"}}`,
+		`{"c":1}`,
+		false,
+	},
+	{
+		`Replacing a keyed array`,
+		`{"containers":[{"name":"a"},{"name":"b"}]}`,
+		`{"containers":[{"$patch":"replace"},{"name":"c"}]}`,
+		`{"containers":[{"name":"c"}]}`,
+		false,
+	},
+	{
+		`Explicit merge directive`,
+		`{"a":{"b":1}}`,
+		`{"a":{"$patch":"merge","c":2}}`,
+		`{"a":{"b":1,"c":2}}`,
+		false,
+	},
+}
+
+func TestStrategic(t *testing.T) {
+	for _, test := range strategicTests {
+		t.Log(test.desc)
+		res, err := ApplyStrategicJSON([]byte(test.src), []byte(test.patch), strategicSchema)
+		if err != nil {
+			t.Errorf("Failed to apply `%v` to `%v` (%v)", test.patch, test.src, err)
+			continue
+		}
+		var final, actual interface{}
+		json.Unmarshal([]byte(test.final), &final)
+		json.Unmarshal(res, &actual)
+		if !reflect.DeepEqual(final, actual) {
+			t.Errorf("Applying `%v` to `%v` gave `%v`, not `%v`", test.patch, test.src, string(res), test.final)
+			continue
+		}
+		if !test.create {
+			continue
+		}
+		p, err := GenerateStrategicJSON([]byte(test.src), []byte(test.final), strategicSchema)
+		if err != nil {
+			t.Errorf("Failed to generate patch from `%v` to `%v` (%v)", test.src, test.final, err)
+			continue
+		}
+		var expected, generated interface{}
+		json.Unmarshal([]byte(test.patch), &expected)
+		json.Unmarshal(p, &generated)
+		if !reflect.DeepEqual(expected, generated) {
+			t.Errorf("Generated patch `%v` is not equal to `%v`", string(p), test.patch)
+		}
+	}
+}
+
+func TestStrategicRoundTrip(t *testing.T) {
+	src := `{"containers":[{"name":"a","ports":[{"port":80},{"port":443}]},{"name":"b"},{"name":"c","env":{"x":"1"}}],"l":[{"name":"x"}],"n":1}`
+	final := `{"containers":[{"name":"d"},{"name":"c","env":{"x":"2"}},{"name":"a","ports":[{"port":443},{"port":8080}]}],"l":[],"n":{"m":1}}`
+	p, err := GenerateStrategicJSON([]byte(src), []byte(final), strategicSchema)
+	if err != nil {
+		t.Fatalf("Failed to generate patch (%v)", err)
+	}
+	res, err := ApplyStrategicJSON([]byte(src), p, strategicSchema)
+	if err != nil {
+		t.Fatalf("Failed to apply generated patch `%v` (%v)", string(p), err)
+	}
+	var expected, actual interface{}
+	json.Unmarshal([]byte(final), &expected)
+	json.Unmarshal(res, &actual)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Applying generated patch `%v` gave `%v`, not `%v`", string(p), string(res), final)
+	}
+}
+
+func TestStrategicBadDirective(t *testing.T) {
+	if _, err := ApplyStrategicJSON([]byte(`{"a":{}}`), []byte(`{"a":{"$patch":"frob"}}`), strategicSchema); err == nil {
+		t.Errorf("Expected an invalid directive to fail")
+	}
+}
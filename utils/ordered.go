@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Object is a JSON object that remembers the order of its keys.  Keys
+// keep the position they were first added at, and new keys go at the
+// end.  Use it in place of map[string]interface{} when the order of
+// keys in a document matters to whoever reads it.
+type Object struct {
+	keys []string
+	vals map[string]interface{}
+}
+
+// NewObject returns an empty Object.
+func NewObject() *Object {
+	return &Object{vals: map[string]interface{}{}}
+}
+
+// Len returns the number of keys in o.
+func (o *Object) Len() int {
+	return len(o.keys)
+}
+
+// Keys returns the keys of o in order.
+func (o *Object) Keys() []string {
+	return append([]string{}, o.keys...)
+}
+
+// Map returns a copy of the members of o.  Changing it does not change
+// o; use Set and Delete for that.
+func (o *Object) Map() map[string]interface{} {
+	res := make(map[string]interface{}, len(o.vals))
+	for k, v := range o.vals {
+		res[k] = v
+	}
+	return res
+}
+
+// Get returns the value of k, and whether o has it.
+func (o *Object) Get(k string) (interface{}, bool) {
+	v, ok := o.vals[k]
+	return v, ok
+}
+
+// Set sets k to v.  If o did not already have k, it is added at the end.
+func (o *Object) Set(k string, v interface{}) {
+	if _, ok := o.vals[k]; !ok {
+		o.keys = append(o.keys, k)
+	}
+	o.vals[k] = v
+}
+
+// Delete removes k from o, and returns whether it was there.
+func (o *Object) Delete(k string) bool {
+	if _, ok := o.vals[k]; !ok {
+		return false
+	}
+	delete(o.vals, k)
+	for i := range o.keys {
+		if o.keys[i] == k {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// MarshalJSON marshals o with its keys in order.
+func (o *Object) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteByte('{')
+	for i, k := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		val, err := json.Marshal(o.vals[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSON unmarshals a JSON object into o, keeping the order of
+// its keys.  Objects nested in it are unmarshalled as Objects too.
+func (o *Object) UnmarshalJSON(buf []byte) error {
+	val, err := UnmarshalOrdered(buf)
+	if err != nil {
+		return err
+	}
+	obj, ok := val.(*Object)
+	if !ok {
+		return fmt.Errorf("Cannot unmarshal %v into an Object", string(buf))
+	}
+	*o = *obj
+	return nil
+}
+
+// UnmarshalOrdered unmarshals buf the same way json.Unmarshal would
+// into an interface{}, except that objects become *Object instead of
+// map[string]interface{}.
+func UnmarshalOrdered(buf []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	res, err := decodeOrdered(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Unexpected data after the JSON value")
+	}
+	return res, nil
+}
+
+func decodeOrdered(dec *json.Decoder) (interface{}, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		res := NewObject()
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			k := tok.(string)
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			res.Set(k, v)
+		}
+		_, err = dec.Token()
+		return res, err
+	case json.Delim('['):
+		res := []interface{}{}
+		for dec.More() {
+			v, err := decodeOrdered(dec)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, v)
+		}
+		_, err = dec.Token()
+		return res, err
+	default:
+		return tok, nil
+	}
+}
+
+// Plain returns a copy of val with every Object in it turned into a
+// map[string]interface{}.
+func Plain(val interface{}) interface{} {
+	switch t := val.(type) {
+	case *Object:
+		res := make(map[string]interface{}, len(t.vals))
+		for k, v := range t.vals {
+			res[k] = Plain(v)
+		}
+		return res
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			res[k] = Plain(v)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(t))
+		for i := range t {
+			res[i] = Plain(t[i])
+		}
+		return res
+	default:
+		return val
+	}
+}
@@ -5,6 +5,7 @@ package utils
 import (
 	"encoding/json"
 	"reflect"
+	"sort"
 )
 
 // Clone performs a deep clone of a JSON-ish structure.
@@ -22,8 +23,76 @@ func Clone(val interface{}) interface{} {
 			res[k] = Clone(v)
 		}
 		return res
-	default:
+	case *Object:
+		res := NewObject()
+		for _, k := range t.keys {
+			res.Set(k, Clone(t.vals[k]))
+		}
+		return res
+	case nil, bool, float64, string:
 		return val
+	default:
+		return cloneValue(reflect.ValueOf(val)).Interface()
+	}
+}
+
+// cloneValue deep-copies values that are not plain JSON, such as
+// typed maps, slices, and structs.  Unexported struct fields are
+// copied as they are.
+func cloneValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		if obj, ok := v.Interface().(*Object); ok {
+			return reflect.ValueOf(Clone(obj))
+		}
+		res := reflect.New(v.Type().Elem())
+		res.Elem().Set(cloneValue(v.Elem()))
+		return res
+	case reflect.Interface:
+		res := reflect.New(v.Type()).Elem()
+		if !v.IsNil() {
+			res.Set(cloneValue(v.Elem()))
+		}
+		return res
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			res.SetMapIndex(iter.Key(), cloneValue(iter.Value()))
+		}
+		return res
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		res := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return res
+	case reflect.Array:
+		res := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			res.Index(i).Set(cloneValue(v.Index(i)))
+		}
+		return res
+	case reflect.Struct:
+		res := reflect.New(v.Type()).Elem()
+		res.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if res.Field(i).CanSet() {
+				res.Field(i).Set(cloneValue(v.Field(i)))
+			}
+		}
+		return res
+	default:
+		return v
 	}
 }
 
@@ -34,13 +103,28 @@ func merge(src, changes interface{}) interface{} {
 	switch srcVal := src.(type) {
 	case map[string]interface{}:
 		changesVal := changes.(map[string]interface{})
-		for k, newVal := range changesVal {
-			if newVal == nil {
+		keys := make([]string, 0, len(changesVal))
+		for k := range changesVal {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if changesVal[k] == nil {
 				delete(srcVal, k)
 				continue
-			} else {
-				srcVal[k] = Merge(srcVal[k], newVal)
 			}
+			srcVal[k] = merge(srcVal[k], changesVal[k])
+		}
+		return srcVal
+	case *Object:
+		changesVal := changes.(*Object)
+		for _, k := range changesVal.keys {
+			if changesVal.vals[k] == nil {
+				srcVal.Delete(k)
+				continue
+			}
+			old, _ := srcVal.Get(k)
+			srcVal.Set(k, merge(old, changesVal.vals[k]))
 		}
 		return srcVal
 	default:
@@ -49,7 +133,10 @@ func merge(src, changes interface{}) interface{} {
 }
 
 // Merge merges changes into src recursively.  The original objects
-// will be left unchanged.
+// will be left unchanged.  Null members of changes remove the matching
+// members of src.  Where src and changes are not both objects, changes
+// replaces src as it is, so unlike RFC 7386 any nulls nested in it are
+// kept.
 func Merge(src, changes interface{}) interface{} {
 	return merge(Clone(src), Clone(changes))
 }
@@ -68,6 +155,21 @@ func MergeJSON(src, changes []byte) ([]byte, error) {
 	return json.Marshal(resObj)
 }
 
+// MergeJSONOrdered does the same as MergeJSON, except that the keys of
+// objects in the result stay in the order they were in src, followed
+// by keys added by changes in the order they were in changes.
+func MergeJSONOrdered(src, changes []byte) ([]byte, error) {
+	srcObj, err := UnmarshalOrdered(src)
+	if err != nil {
+		return nil, err
+	}
+	changesObj, err := UnmarshalOrdered(changes)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(srcObj, changesObj))
+}
+
 // Remarshal marshals src and then unmarshals it into target.
 func Remarshal(src, target interface{}) error {
 	r, err := json.Marshal(src)
@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// mergeTests are the examples from Appendix A of RFC 7386, except that
+// changes which replace a value that is not an object keep their nulls.
+var mergeTests = []struct {
+	src, changes, result string
+}{
+	{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+	{`{"a":"b"}`, `{"a":null}`, `{}`},
+	{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+	{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+	{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+	{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+	{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+	{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+	{`{"a":"b"}`, `["c"]`, `["c"]`},
+	{`{"a":"foo"}`, `null`, `null`},
+	{`{"a":"foo"}`, `"bar"`, `"bar"`},
+	{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+	{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b","c":null}`},
+	{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{"ccc":null}}}`},
+}
+
+func TestMerge(t *testing.T) {
+	for _, test := range mergeTests {
+		var src, changes, expected interface{}
+		json.Unmarshal([]byte(test.src), &src)
+		json.Unmarshal([]byte(test.changes), &changes)
+		json.Unmarshal([]byte(test.result), &expected)
+		if res := Merge(src, changes); !reflect.DeepEqual(res, expected) {
+			t.Errorf("Merging %v into %v: expected %v, got %#v", test.changes, test.src, test.result, res)
+		}
+		var origSrc, origChanges interface{}
+		json.Unmarshal([]byte(test.src), &origSrc)
+		json.Unmarshal([]byte(test.changes), &origChanges)
+		if !reflect.DeepEqual(src, origSrc) || !reflect.DeepEqual(changes, origChanges) {
+			t.Errorf("Merging %v into %v modified its arguments", test.changes, test.src)
+		}
+		buf, err := MergeJSON([]byte(test.src), []byte(test.changes))
+		if err != nil {
+			t.Errorf("Failed to merge %v into %v: %v", test.changes, test.src, err)
+			continue
+		}
+		var res interface{}
+		json.Unmarshal(buf, &res)
+		if !reflect.DeepEqual(res, expected) {
+			t.Errorf("MergeJSON of %v into %v: expected %v, got %v", test.changes, test.src, test.result, string(buf))
+		}
+	}
+}
+
+func TestObjectMapIsACopy(t *testing.T) {
+	obj := NewObject()
+	obj.Set("b", 1.0)
+	obj.Set("a", 2.0)
+	m := obj.Map()
+	m["c"] = 3.0
+	delete(m, "b")
+	if _, ok := obj.Get("c"); ok || obj.Len() != 2 {
+		t.Errorf("Changing the map from Map changed the object")
+	}
+	buf, _ := json.Marshal(obj)
+	if string(buf) != `{"b":1,"a":2}` {
+		t.Errorf("Expected {\"b\":1,\"a\":2}, got %v", string(buf))
+	}
+}
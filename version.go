@@ -0,0 +1,90 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// ErrConflict is returned by ApplyVersioned when the version in the
+// document being patched is not the one the patch was generated
+// against, which means someone else changed the document first.
+type ErrConflict struct {
+	// Path is the JSON pointer to the version field.
+	Path string
+	// Expected is the version the patch was generated against.
+	Expected interface{}
+	// Actual is the version found in the document, or nil if the
+	// document does not have one.
+	Actual interface{}
+}
+
+func (e *ErrConflict) Error() string {
+	return fmt.Sprintf("Conflict at %v: expected version %v, found %v", e.Path, e.Expected, e.Actual)
+}
+
+// IncrementVersion bumps a version by one.  It handles JSON numbers
+// and strings that contain an integer, which is how most APIs that
+// use resourceVersion-style fields encode them.  It is intended to be
+// used as GenerateOptions.BumpVersion.
+func IncrementVersion(version interface{}) (interface{}, error) {
+	switch t := version.(type) {
+	case float64:
+		return t + 1, nil
+	case json.Number:
+		i, err := t.Int64()
+		if err != nil {
+			return nil, err
+		}
+		return json.Number(strconv.FormatInt(i+1, 10)), nil
+	case string:
+		i, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot increment version `%v`: %v", t, err)
+		}
+		return strconv.FormatInt(i+1, 10), nil
+	default:
+		return nil, fmt.Errorf("Cannot increment version %#v", version)
+	}
+}
+
+// ApplyVersioned does the same thing as Apply, except that rawPatch
+// must start with a test of the version field at versionPath, as
+// generated by GenerateWithOptions with Paranoia set to TestVersion.
+// If that test fails, err will be an *ErrConflict.
+func ApplyVersioned(base interface{}, rawPatch []byte, versionPath string) (result interface{}, err error, loc int) {
+	p, err := NewPatch(rawPatch)
+	if err != nil {
+		return nil, err, 0
+	}
+	ptr, err := NewPointer(versionPath)
+	if err != nil {
+		return nil, err, 0
+	}
+	if len(p) == 0 || p[0].Op != "test" || !p[0].Path.Equal(ptr) {
+		return nil, fmt.Errorf("Patch does not start by testing the version at `%v`", versionPath), 0
+	}
+	result, err, loc = p.Apply(base, ApplyOptions{})
+	if err != nil && loc == 0 {
+		actual, _ := ptr.Get(base)
+		return result, &ErrConflict{Path: versionPath, Expected: p[0].Value, Actual: actual}, 0
+	}
+	return result, err, loc
+}
+
+// ApplyJSONVersioned does the same thing as ApplyVersioned, except
+// the inputs should be JSON-containing byte arrays instead of
+// unmarshalled JSON
+func ApplyJSONVersioned(base, rawPatch []byte, versionPath string) (result []byte, err error, loc int) {
+	var rawBase interface{}
+	err = json.Unmarshal(base, &rawBase)
+	if err != nil {
+		return nil, err, 0
+	}
+	rawRes, err, loc := ApplyVersioned(rawBase, rawPatch, versionPath)
+	if err != nil {
+		return nil, err, loc
+	}
+	result, err = json.Marshal(rawRes)
+	return result, err, loc
+}
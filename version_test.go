@@ -0,0 +1,78 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIncrementVersion(t *testing.T) {
+	tests := []struct {
+		in, out interface{}
+		valid   bool
+	}{
+		{float64(1), float64(2), true},
+		{"41", "42", true},
+		{json.Number("9"), json.Number("10"), true},
+		{"abc", nil, false},
+		{true, nil, false},
+	}
+	for _, test := range tests {
+		res, err := IncrementVersion(test.in)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("Expected incrementing %#v to fail, got %#v", test.in, res)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed to increment %#v (%v)", test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(res, test.out) {
+			t.Errorf("Incrementing %#v gave %#v, not %#v", test.in, res, test.out)
+		}
+	}
+}
+
+func TestVersionedPatch(t *testing.T) {
+	opts := GenerateOptions{
+		Paranoia:    TestVersion,
+		VersionPath: "/metadata/resourceVersion",
+		BumpVersion: IncrementVersion,
+	}
+	base := []byte(`{"metadata":{"resourceVersion":"7"},"a":1}`)
+	target := []byte(`{"metadata":{"resourceVersion":"12"},"a":2}`)
+	p, err := GenerateJSONWithOptions(base, target, opts)
+	if err != nil {
+		t.Fatalf("Failed to generate versioned patch (%v)", err)
+	}
+	expected := `[{"op":"test","path":"/metadata/resourceVersion","value":"7"},{"op":"replace","path":"/metadata/resourceVersion","value":"8"},{"op":"replace","path":"/a","value":2}]`
+	if string(p) != expected {
+		t.Fatalf("Generated patch \n\t`%v` \nis not equal to expected patch \n\t`%v`", string(p), expected)
+	}
+	res, err, _ := ApplyJSONVersioned(base, p, opts.VersionPath)
+	if err != nil {
+		t.Fatalf("Failed to apply versioned patch (%v)", err)
+	}
+	if string(res) != `{"a":2,"metadata":{"resourceVersion":"8"}}` {
+		t.Errorf("Applying versioned patch gave `%v`", string(res))
+	}
+	// Someone else got there first.
+	_, err, loc := ApplyJSONVersioned(res, p, opts.VersionPath)
+	conflict, ok := err.(*ErrConflict)
+	if !ok {
+		t.Fatalf("Expected an *ErrConflict, got %#v", err)
+	}
+	if loc != 0 || conflict.Expected != "7" || conflict.Actual != "8" {
+		t.Errorf("Unexpected conflict %#v at %v", conflict, loc)
+	}
+	// Patches that do not check the version are refused.
+	_, err, _ = ApplyJSONVersioned(base, []byte(`[{"op":"replace","path":"/a","value":3}]`), opts.VersionPath)
+	if err == nil {
+		t.Errorf("Expected a patch without a version test to be refused")
+	}
+	if _, ok := err.(*ErrConflict); ok {
+		t.Errorf("A patch without a version test is not a conflict")
+	}
+}
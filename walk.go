@@ -0,0 +1,73 @@
+package jsonpatch
+
+import "errors"
+
+// SkipSubtree can be returned by a WalkFunc to keep Walk from visiting
+// anything below the current value.  It is not returned as an error
+// by Walk.
+var SkipSubtree = errors.New("skip this subtree")
+
+// WalkFunc is called by Walk for every value in a document, along with
+// a pointer to where the value is.  If it returns an error other than
+// SkipSubtree, the walk stops and Walk returns that error.
+type WalkFunc func(ptr Pointer, val interface{}) error
+
+func walk(ptr Pointer, val interface{}, fn WalkFunc) error {
+	if err := fn(ptr, val); err != nil {
+		if err == SkipSubtree {
+			return nil
+		}
+		return err
+	}
+	for _, child := range children(ptr, val) {
+		if err := walk(child.ptr, child.val, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Walk calls fn for every value in doc, starting with doc itself.
+// Parents are visited before their children, object members are
+// visited in sorted key order, or in key order for a *utils.Object,
+// and array elements in index order.
+//
+// doc must be the result of unmarshalling JSON into an interface{},
+// or of utils.UnmarshalOrdered.  fn may keep the pointers it is passed, but should not modify doc.
+func Walk(doc interface{}, fn WalkFunc) error {
+	return walk(make(Pointer, 0), doc, fn)
+}
+
+// Find returns pointers to every value in doc for which pred returns
+// true, in the order Walk would visit them.
+func Find(doc interface{}, pred func(ptr Pointer, val interface{}) bool) []Pointer {
+	res := make([]Pointer, 0)
+	Walk(doc, func(ptr Pointer, val interface{}) error {
+		if pred(ptr, val) {
+			res = append(res, ptr)
+		}
+		return nil
+	})
+	return res
+}
+
+// Leaf is a value in a document that has no children, along with
+// where it is.
+type Leaf struct {
+	Pointer Pointer
+	Value   interface{}
+}
+
+// Leaves returns every value in doc that has no children, which is
+// every value except non-empty objects and arrays, in the order Walk
+// would visit them.
+func Leaves(doc interface{}) []Leaf {
+	res := make([]Leaf, 0)
+	Walk(doc, func(ptr Pointer, val interface{}) error {
+		if len(children(ptr, val)) == 0 {
+			res = append(res, Leaf{ptr, val})
+		}
+		return nil
+	})
+	return res
+}
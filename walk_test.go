@@ -0,0 +1,99 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/VictorLowther/jsonpatch/utils"
+)
+
+const walkDoc = `{"b":[1,{"c":"x"}],"a":{"secret":"y","d":[]},"e":null}`
+
+func ptrStrings(ptrs []Pointer) []string {
+	res := make([]string, len(ptrs))
+	for i := range ptrs {
+		res[i] = ptrs[i].String()
+	}
+	return res
+}
+
+func TestWalk(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(walkDoc), &doc)
+	visited := make([]Pointer, 0)
+	err := Walk(doc, func(ptr Pointer, val interface{}) error {
+		visited = append(visited, ptr)
+		if ptr.String() == "/a" {
+			return SkipSubtree
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed (%v)", err)
+	}
+	expected := []string{``, `/a`, `/b`, `/b/0`, `/b/1`, `/b/1/c`, `/e`}
+	if actual := ptrStrings(visited); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Walk visited %#v, not %#v", actual, expected)
+	}
+	stop := errors.New("stop")
+	count := 0
+	err = Walk(doc, func(ptr Pointer, val interface{}) error {
+		count++
+		if len(ptr) == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || count != 3 {
+		t.Errorf("Expected Walk to stop after 3 values with our error, got %v after %v", err, count)
+	}
+}
+
+func TestFind(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(walkDoc), &doc)
+	found := Find(doc, func(ptr Pointer, val interface{}) bool {
+		_, isString := val.(string)
+		return isString
+	})
+	expected := []string{`/a/secret`, `/b/1/c`}
+	if actual := ptrStrings(found); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Find found %#v, not %#v", actual, expected)
+	}
+}
+
+func TestLeafValues(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(walkDoc), &doc)
+	leaves := Leaves(doc)
+	expected := []string{`/a/d`, `/a/secret`, `/b/0`, `/b/1/c`, `/e`}
+	ptrs := make([]Pointer, len(leaves))
+	for i := range leaves {
+		ptrs[i] = leaves[i].Pointer
+		val, err := leaves[i].Pointer.Get(doc)
+		if err != nil || !reflect.DeepEqual(val, leaves[i].Value) {
+			t.Errorf("Leaf %v has value %#v, but the document has %#v", leaves[i].Pointer, leaves[i].Value, val)
+		}
+	}
+	if actual := ptrStrings(ptrs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Leaves found %#v, not %#v", actual, expected)
+	}
+}
+
+func TestWalkOrdered(t *testing.T) {
+	doc, err := utils.UnmarshalOrdered([]byte(walkDoc))
+	if err != nil {
+		t.Fatalf("Bad test document (%v)", err)
+	}
+	leaves := Leaves(doc)
+	ptrs := make([]Pointer, len(leaves))
+	for i := range leaves {
+		ptrs[i] = leaves[i].Pointer
+	}
+	expected := []string{`/b/0`, `/b/1/c`, `/a/secret`, `/a/d`, `/e`}
+	if actual := ptrStrings(ptrs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Leaves found %#v, not %#v", actual, expected)
+	}
+}
@@ -0,0 +1,324 @@
+// Package yamlpatch applies JSON patches to YAML documents and
+// generates them from pairs of YAML documents.  Patches are applied to
+// the parsed YAML node tree, so comments, key order, anchors, and
+// quoting in the parts of a document that a patch leaves alone
+// survive the round trip.  Patches can be written in JSON or YAML.
+package yamlpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/VictorLowther/jsonpatch"
+	"github.com/VictorLowther/jsonpatch/internal/docutil"
+	"gopkg.in/yaml.v3"
+)
+
+// fromYAML turns a value decoded by the YAML library into the same
+// sort of value encoding/json would produce.
+func fromYAML(val interface{}) (interface{}, error) {
+	switch t := val.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			nv, err := fromYAML(v)
+			if err != nil {
+				return nil, err
+			}
+			t[k] = nv
+		}
+		return t, nil
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			nv, err := fromYAML(v)
+			if err != nil {
+				return nil, err
+			}
+			key, err := yamlKey(k)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = nv
+		}
+		return res, nil
+	case []interface{}:
+		for i := range t {
+			nv, err := fromYAML(t[i])
+			if err != nil {
+				return nil, err
+			}
+			t[i] = nv
+		}
+		return t, nil
+	case int:
+		return float64(t), nil
+	case int64:
+		return float64(t), nil
+	case uint64:
+		return float64(t), nil
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, fmt.Errorf("%v cannot be represented in JSON", t)
+		}
+		return t, nil
+	case time.Time:
+		return t.Format(time.RFC3339Nano), nil
+	case string, bool, nil:
+		return t, nil
+	default:
+		return nil, fmt.Errorf("Cannot convert YAML value %#v", val)
+	}
+}
+
+// yamlKey turns a decoded YAML mapping key into an object key.
+func yamlKey(k interface{}) (string, error) {
+	switch k.(type) {
+	case nil:
+		return "null", nil
+	case string, bool, int, int64, uint64, float64:
+		return fmt.Sprint(k), nil
+	default:
+		return "", fmt.Errorf("Cannot use %v as an object key", k)
+	}
+}
+
+// yamlDoc holds a parsed YAML document, along with its JSON equivalent.
+type yamlDoc struct {
+	root   *yaml.Node
+	val    interface{}
+	indent int
+}
+
+// yamlIndent guesses how much the document at buf indents nested
+// values, so that we can write it back out the same way.
+func yamlIndent(buf []byte) int {
+	res := 0
+	for _, line := range strings.Split(string(buf), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if n := len(line) - len(trimmed); n > 0 && (res == 0 || n < res) {
+			res = n
+		}
+	}
+	if res < 2 {
+		return 2
+	}
+	return res
+}
+
+func parseYAML(buf []byte) (*yamlDoc, error) {
+	res := &yamlDoc{root: &yaml.Node{}, indent: yamlIndent(buf)}
+	if err := yaml.Unmarshal(buf, res.root); err != nil {
+		return nil, err
+	}
+	if res.root.Kind == 0 {
+		return nil, fmt.Errorf("No YAML document found")
+	}
+	var val interface{}
+	if err := res.root.Decode(&val); err != nil {
+		return nil, err
+	}
+	var err error
+	res.val, err = fromYAML(val)
+	return res, err
+}
+
+// copyNode deep-copies n, leaving out any anchors.
+func copyNode(n *yaml.Node) *yaml.Node {
+	res := *n
+	res.Anchor = ""
+	res.Content = make([]*yaml.Node, len(n.Content))
+	for i := range n.Content {
+		res.Content[i] = copyNode(n.Content[i])
+	}
+	return &res
+}
+
+// detach replaces every alias of anchor in the document with a copy of
+// it, so that anchor can be changed without changing them.
+func (d *yamlDoc) detach(anchor *yaml.Node) {
+	var walk func(*yaml.Node)
+	walk = func(n *yaml.Node) {
+		if n.Kind == yaml.AliasNode && n.Alias == anchor {
+			*n = *copyNode(anchor)
+			return
+		}
+		for _, c := range n.Content {
+			walk(c)
+		}
+	}
+	walk(d.root)
+	anchor.Anchor = ""
+}
+
+// replace makes n hold val, keeping the comments attached to it.
+func (d *yamlDoc) replace(n *yaml.Node, val interface{}) error {
+	fresh := &yaml.Node{}
+	if err := fresh.Encode(val); err != nil {
+		return err
+	}
+	fresh.HeadComment, fresh.LineComment, fresh.FootComment = n.HeadComment, n.LineComment, n.FootComment
+	if fresh.Kind == yaml.ScalarNode && n.Kind == yaml.ScalarNode && fresh.Tag == n.Tag {
+		fresh.Style = n.Style
+	}
+	*n = *fresh
+	return nil
+}
+
+// hasMergeKey returns true if the mapping n pulls in other mappings
+// with `<<`.
+func hasMergeKey(n *yaml.Node) bool {
+	for i := 0; i < len(n.Content); i += 2 {
+		if n.Content[i].Tag == "!!merge" {
+			return true
+		}
+	}
+	return false
+}
+
+// update changes n from holding base to holding target.  Nodes whose
+// value does not change are left alone, along with their comments and
+// formatting.
+func (d *yamlDoc) update(n *yaml.Node, base, target interface{}) error {
+	if n.Kind == yaml.DocumentNode {
+		return d.update(n.Content[0], base, target)
+	}
+	if reflect.DeepEqual(base, target) {
+		return nil
+	}
+	if n.Anchor != "" {
+		d.detach(n)
+	}
+	switch n.Kind {
+	case yaml.MappingNode:
+		baseVal, ok1 := base.(map[string]interface{})
+		targetVal, ok2 := target.(map[string]interface{})
+		if !ok1 || !ok2 || hasMergeKey(n) {
+			break
+		}
+		content := make([]*yaml.Node, 0, len(n.Content))
+		for i := 0; i < len(n.Content); i += 2 {
+			var decoded interface{}
+			if err := n.Content[i].Decode(&decoded); err != nil {
+				return err
+			}
+			k, err := yamlKey(decoded)
+			if err != nil {
+				return err
+			}
+			v, ok := targetVal[k]
+			if !ok {
+				continue
+			}
+			if err := d.update(n.Content[i+1], baseVal[k], v); err != nil {
+				return err
+			}
+			content = append(content, n.Content[i], n.Content[i+1])
+		}
+		for _, k := range docutil.SortedKeys(targetVal) {
+			if _, ok := baseVal[k]; ok {
+				continue
+			}
+			key, val := &yaml.Node{}, &yaml.Node{}
+			if err := key.Encode(k); err != nil {
+				return err
+			}
+			if err := val.Encode(targetVal[k]); err != nil {
+				return err
+			}
+			content = append(content, key, val)
+		}
+		n.Content = content
+		return nil
+	case yaml.SequenceNode:
+		baseVal, ok1 := base.([]interface{})
+		targetVal, ok2 := target.([]interface{})
+		if !ok1 || !ok2 {
+			break
+		}
+		// Pair elements up the same way ApplyOptions.PreserveFormat does, so that
+		// comments stay with their elements when others are inserted
+		// or removed around them.
+		pairs := docutil.Align(baseVal, targetVal, reflect.DeepEqual)
+		content := make([]*yaml.Node, 0, len(targetVal))
+		for j, v := range targetVal {
+			if i := pairs[j]; i != -1 {
+				if err := d.update(n.Content[i], baseVal[i], v); err != nil {
+					return err
+				}
+				content = append(content, n.Content[i])
+				continue
+			}
+			val := &yaml.Node{}
+			if err := val.Encode(v); err != nil {
+				return err
+			}
+			content = append(content, val)
+		}
+		n.Content = content
+		return nil
+	}
+	return d.replace(n, target)
+}
+
+func (d *yamlDoc) marshal() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(d.indent)
+	if err := enc.Encode(d.root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Apply applies rawPatch to the YAML document in base, and returns
+// the patched document as YAML.  The patch can be either JSON or YAML.
+// The parts of base that the patch does not change keep their order,
+// comments, and formatting.
+func Apply(base, rawPatch []byte) (result []byte, err error, loc int) {
+	doc, err := parseYAML(base)
+	if err != nil {
+		return nil, err, 0
+	}
+	patchDoc, err := parseYAML(rawPatch)
+	if err != nil {
+		return nil, err, 0
+	}
+	buf, err := json.Marshal(patchDoc.val)
+	if err != nil {
+		return nil, err, 0
+	}
+	res, err, loc := jsonpatch.Apply(doc.val, buf)
+	if err != nil {
+		return nil, err, loc
+	}
+	if err := doc.update(doc.root, doc.val, res); err != nil {
+		return nil, err, 0
+	}
+	result, err = doc.marshal()
+	return result, err, 0
+}
+
+// Generate generates a JSON patch that will turn the YAML document
+// in base into the YAML document in target.
+func Generate(base, target []byte, paranoid bool) ([]byte, error) {
+	baseDoc, err := parseYAML(base)
+	if err != nil {
+		return nil, err
+	}
+	targetDoc, err := parseYAML(target)
+	if err != nil {
+		return nil, err
+	}
+	return jsonpatch.Generate(baseDoc.val, targetDoc.val, paranoid)
+}
@@ -0,0 +1,139 @@
+package yamlpatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type yamlTest struct {
+	base, patch, result string
+}
+
+var yamlTests = []yamlTest{
+	{
+		`# The config
+name: thing  # its name
+replicas: 1
+ports:
+  - 80
+  - 443
+`,
+		`[{"op":"replace","path":"/replicas","value":3}]`,
+		`# The config
+name: thing # its name
+replicas: 3
+ports:
+  - 80
+  - 443
+`,
+	},
+	{
+		`zeta: 1
+# alpha comes second
+alpha:
+    nested: true
+    other: "quoted"
+`,
+		`- {op: add, path: /alpha/new, value: [1, 2]}
+- {op: replace, path: /alpha/other, value: changed}
+- {op: remove, path: /zeta}
+`,
+		`# alpha comes second
+alpha:
+    nested: true
+    other: "changed"
+    new:
+        - 1
+        - 2
+`,
+	},
+	{
+		`base: &base
+  a: 1
+copy: *base
+list: [1, 2, 3]
+`,
+		`[{"op":"replace","path":"/base/a","value":2},{"op":"remove","path":"/list/1"}]`,
+		`base:
+  a: 2
+copy:
+  a: 1
+list: [1, 3]
+`,
+	},
+	{
+		`1: one
+true: yes
+`,
+		`[{"op":"add","path":"/2","value":"two"}]`,
+		`1: one
+true: yes
+"2": two
+`,
+	},
+	{
+		`ports:
+  - 80 # http
+  - 443 # https
+  - 8080 # alt
+`,
+		`[{"op":"remove","path":"/ports/0"}]`,
+		`ports:
+  - 443 # https
+  - 8080 # alt
+`,
+	},
+	{
+		`items:
+  # first
+  - a
+  - b # second
+`,
+		`[{"op":"add","path":"/items/0","value":"new"},{"op":"replace","path":"/items/2","value":"c"}]`,
+		`items:
+  - new
+  # first
+  - a
+  - c # second
+`,
+	},
+}
+
+func TestApply(t *testing.T) {
+	for _, test := range yamlTests {
+		res, err, _ := Apply([]byte(test.base), []byte(test.patch))
+		if err != nil {
+			t.Errorf("Failed to apply %v to %v (%v)", test.patch, test.base, err)
+			continue
+		}
+		if string(res) != test.result {
+			t.Errorf("Applying %v: expected\n%v\ngot\n%v", test.patch, test.result, string(res))
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	base := `a: 1
+b: {c: [1, 2]}
+5: five
+`
+	target := `5: five
+a: 2
+b:
+  c: [1, 2, 3]
+`
+	p, err := Generate([]byte(base), []byte(target), false)
+	if err != nil {
+		t.Fatalf("Failed to generate patch: %v", err)
+	}
+	var expected, got interface{}
+	json.Unmarshal([]byte(`[{"op":"replace","path":"/a","value":2},{"op":"replace","path":"/b/c","value":[1,2,3]}]`), &expected)
+	json.Unmarshal(p, &got)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected patch %v, got %v", expected, string(p))
+	}
+	if _, err := Generate([]byte(`[1, .nan]`), []byte(`[]`), false); err == nil {
+		t.Errorf("Expected NaN to be rejected")
+	}
+}